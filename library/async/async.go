@@ -1,28 +1,105 @@
 package async
 
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
 func Go[T any](fn func() (T, error)) Result[T] {
-	ch := make(chan result[T], 1)
+	r := newResult[T](nil)
+	go func() {
+		res, err := call(fn)
+		r.set(res, err)
+	}()
+	return r
+}
+
+// ctxがキャンセルされるかAwaitContextで待つのを諦めるとfnに渡すctxもキャンセルされる
+func GoContext[T any](ctx context.Context, fn func(ctx context.Context) (T, error)) Result[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	r := newResult[T](cancel)
 	go func() {
-		res, err := fn()
-		ch <- result[T]{
-			res: res,
-			err: err,
+		defer cancel()
+		res, err := call(func() (T, error) {
+			return fn(ctx)
+		})
+		r.set(res, err)
+	}()
+	return r
+}
+
+// fn内のpanicはrecoverして*PanicErrorとして返す
+func call[T any](fn func() (T, error)) (res T, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{
+				Value: v,
+				Stack: debug.Stack(),
+			}
 		}
-		close(ch)
 	}()
-	return Result[T]{ch: ch}
+	return fn()
+}
+
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
 }
 
+// 結果は一度だけセットされ、何度でも、複数のgoroutineからでもAwaitできる
 type Result[T any] struct {
-	ch chan result[T]
+	*result[T]
+}
+
+type result[T any] struct {
+	done   chan struct{}
+	res    T
+	err    error
+	cancel context.CancelFunc
+}
+
+func newResult[T any](cancel context.CancelFunc) Result[T] {
+	return Result[T]{
+		result: &result[T]{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		},
+	}
+}
+
+func (r *result[T]) set(res T, err error) {
+	r.res, r.err = res, err
+	close(r.done)
 }
 
 func (r *Result[T]) Await() (T, error) {
-	result := <-r.ch
-	return result.res, result.err
+	<-r.done
+	return r.res, r.err
 }
 
-type result[T any] struct {
-	res T
-	err error
+func (r *Result[T]) AwaitContext(ctx context.Context) (res T, err error) {
+	select {
+	case <-r.done:
+		return r.res, r.err
+	case <-ctx.Done():
+		if r.cancel != nil {
+			r.cancel()
+		}
+		return res, ctx.Err()
+	}
+}
+
+// 完了していなければokはfalse
+func (r *Result[T]) TryGet() (res T, err error, ok bool) {
+	select {
+	case <-r.done:
+		return r.res, r.err, true
+	default:
+		return res, nil, false
+	}
 }
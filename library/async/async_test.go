@@ -0,0 +1,364 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Go(t *testing.T) {
+	r := Go(func() (int, error) {
+		time.Sleep(time.Millisecond * 10)
+		return 1, nil
+	})
+	v, err := r.Await()
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 1 {
+		t.Errorf("v want %v, got %v", 1, v)
+	}
+
+	r = Go(func() (int, error) {
+		return 0, errors.New("something")
+	})
+	_, err = r.Await()
+	if err == nil {
+		t.Errorf("err want %v, got %v", errors.New("something"), err)
+	}
+}
+
+func Test_GoContext(t *testing.T) {
+	canceled := make(chan struct{})
+	r := GoContext(context.Background(), func(ctx context.Context) (int, error) {
+		select {
+		case <-ctx.Done():
+			close(canceled)
+			return 0, ctx.Err()
+		case <-time.After(time.Second):
+			return 1, nil
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := r.AwaitContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err want %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Millisecond * 100):
+		t.Errorf("fn is not canceled")
+	}
+
+	r = GoContext(context.Background(), func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	v, err := r.AwaitContext(context.Background())
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 1 {
+		t.Errorf("v want %v, got %v", 1, v)
+	}
+}
+
+func sleepGo(d time.Duration, v int, err error) Result[int] {
+	return Go(func() (int, error) {
+		time.Sleep(d)
+		return v, err
+	})
+}
+
+func Test_All(t *testing.T) {
+	res, err := All(
+		sleepGo(time.Millisecond*30, 1, nil),
+		sleepGo(time.Millisecond*10, 2, nil),
+		sleepGo(time.Millisecond*20, 3, nil),
+	)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	for i, v := range []int{1, 2, 3} {
+		if res[i] != v {
+			t.Errorf("res[%v] want %v, got %v", i, v, res[i])
+		}
+	}
+
+	start := time.Now()
+	_, err = All(
+		sleepGo(time.Second, 1, nil),
+		sleepGo(time.Millisecond*10, 0, errors.New("something")),
+	)
+	if err == nil {
+		t.Errorf("err want %v, got %v", errors.New("something"), err)
+	}
+	if time.Since(start) > time.Millisecond*500 {
+		t.Errorf("All does not short-circuit")
+	}
+}
+
+func Test_Any(t *testing.T) {
+	v, err := Any(
+		sleepGo(time.Millisecond*10, 0, errors.New("something")),
+		sleepGo(time.Millisecond*20, 2, nil),
+		sleepGo(time.Millisecond*30, 3, nil),
+	)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 2 {
+		t.Errorf("v want %v, got %v", 2, v)
+	}
+
+	_, err = Any(
+		sleepGo(time.Millisecond*10, 0, errors.New("something1")),
+		sleepGo(time.Millisecond*20, 0, errors.New("something2")),
+	)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("err want Errors with 2 errors, got %v", err)
+	}
+
+	_, err = Any[int]()
+	if !errors.Is(err, ErrNoResults) {
+		t.Errorf("err want %v, got %v", ErrNoResults, err)
+	}
+}
+
+func Test_Race(t *testing.T) {
+	_, err := Race(
+		sleepGo(time.Millisecond*10, 0, errors.New("something")),
+		sleepGo(time.Millisecond*30, 2, nil),
+	)
+	if err == nil {
+		t.Errorf("err want %v, got %v", errors.New("something"), err)
+	}
+
+	v, err := Race(
+		sleepGo(time.Millisecond*30, 0, errors.New("something")),
+		sleepGo(time.Millisecond*10, 2, nil),
+	)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 2 {
+		t.Errorf("v want %v, got %v", 2, v)
+	}
+}
+
+func Test_AllSettled(t *testing.T) {
+	res := AllSettled(
+		sleepGo(time.Millisecond*20, 1, nil),
+		sleepGo(time.Millisecond*10, 0, errors.New("something")),
+	)
+	if len(res) != 2 {
+		t.Fatalf("len(res) want %v, got %v", 2, len(res))
+	}
+	if res[0].Res != 1 || res[0].Err != nil {
+		t.Errorf("res[0] want %v, got %v", Settled[int]{Res: 1}, res[0])
+	}
+	if res[1].Err == nil {
+		t.Errorf("res[1].Err want %v, got %v", errors.New("something"), res[1].Err)
+	}
+}
+
+func Test_Result_multipleAwait(t *testing.T) {
+	r := sleepGo(time.Millisecond*20, 1, errors.New("something"))
+
+	_, _, ok := r.TryGet()
+	if ok {
+		t.Errorf("ok want %v, got %v", false, ok)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			v, err := r.Await()
+			if v != 1 {
+				t.Errorf("v want %v, got %v", 1, v)
+			}
+			if err == nil {
+				t.Errorf("err want %v, got %v", errors.New("something"), err)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	v, err, ok := r.TryGet()
+	if !ok {
+		t.Errorf("ok want %v, got %v", true, ok)
+	}
+	if v != 1 {
+		t.Errorf("v want %v, got %v", 1, v)
+	}
+	if err == nil {
+		t.Errorf("err want %v, got %v", errors.New("something"), err)
+	}
+}
+
+func Test_Pool(t *testing.T) {
+	p := NewPool(3, -1)
+	var running, max int64
+	m := sync.Mutex{}
+	results := make([]Result[int], 0, 30)
+	for i := 0; i < 30; i++ {
+		results = append(results, GoPool(p, func() (int, error) {
+			m.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			m.Unlock()
+			time.Sleep(time.Millisecond * 10)
+			m.Lock()
+			running--
+			m.Unlock()
+			return 1, nil
+		}))
+	}
+	if p.Running() > 3 {
+		t.Errorf("p.Running() want <= %v, got %v", 3, p.Running())
+	}
+	res, err := All(results...)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if len(res) != 30 {
+		t.Errorf("len(res) want %v, got %v", 30, len(res))
+	}
+	if max != 3 {
+		t.Errorf("max want %v, got %v", 3, max)
+	}
+	if p.Running() != 0 || p.Queued() != 0 {
+		t.Errorf("p.Running(), p.Queued() want %v, %v, got %v, %v", 0, 0, p.Running(), p.Queued())
+	}
+}
+
+func Test_Pool_queue(t *testing.T) {
+	p := NewPool(1, 1)
+	block := make(chan struct{})
+	fn := func() (int, error) {
+		<-block
+		return 1, nil
+	}
+	GoPool(p, fn)
+	GoPool(p, fn)
+
+	submitted := make(chan struct{})
+	go func() {
+		GoPool(p, fn)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Errorf("GoPool does not block when queue is full")
+	case <-time.After(time.Millisecond * 20):
+	}
+	if p.Running() != 1 || p.Queued() != 1 {
+		t.Errorf("p.Running(), p.Queued() want %v, %v, got %v, %v", 1, 1, p.Running(), p.Queued())
+	}
+	close(block)
+	<-submitted
+}
+
+func Test_Pool_size(t *testing.T) {
+	p := NewPool(0, -1)
+	r := GoPool(p, func() (int, error) {
+		return 1, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := r.AwaitContext(ctx)
+	if err != nil || res != 1 {
+		t.Errorf("res, err want %v, %v, got %v, %v", 1, nil, res, err)
+	}
+}
+
+func Test_Go_panic(t *testing.T) {
+	r := Go(func() (int, error) {
+		panic("something")
+	})
+	_, err := r.Await()
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err want %T, got %v", pe, err)
+	}
+	if pe.Value != "something" {
+		t.Errorf("pe.Value want %v, got %v", "something", pe.Value)
+	}
+	if len(pe.Stack) == 0 {
+		t.Errorf("pe.Stack is empty")
+	}
+
+	r = GoContext(context.Background(), func(ctx context.Context) (int, error) {
+		panic("something")
+	})
+	_, err = r.Await()
+	if !errors.As(err, &pe) {
+		t.Errorf("err want %T, got %v", pe, err)
+	}
+
+	r = GoPool(NewPool(1, -1), func() (int, error) {
+		panic("something")
+	})
+	_, err = r.Await()
+	if !errors.As(err, &pe) {
+		t.Errorf("err want %T, got %v", pe, err)
+	}
+}
+
+func Test_Then(t *testing.T) {
+	r := Then(sleepGo(time.Millisecond*10, 1, nil), func(v int) (string, error) {
+		return fmt.Sprintf("v:%v", v), nil
+	})
+	s, err := r.Await()
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if s != "v:1" {
+		t.Errorf("s want %v, got %v", "v:1", s)
+	}
+
+	called := false
+	r = Then(sleepGo(time.Millisecond*10, 0, errors.New("something")), func(v int) (string, error) {
+		called = true
+		return "", nil
+	})
+	_, err = r.Await()
+	if err == nil {
+		t.Errorf("err want %v, got %v", errors.New("something"), err)
+	}
+	if called {
+		t.Errorf("fn is called after error")
+	}
+
+	m := Map(sleepGo(time.Millisecond*10, 1, nil), func(v int) int {
+		return v * 2
+	})
+	v, err := m.Await()
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 2 {
+		t.Errorf("v want %v, got %v", 2, v)
+	}
+
+	m = FlatMap(sleepGo(time.Millisecond*10, 1, nil), func(v int) Result[int] {
+		return sleepGo(time.Millisecond*10, v*3, nil)
+	})
+	v, err = m.Await()
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if v != 3 {
+		t.Errorf("v want %v, got %v", 3, v)
+	}
+}
@@ -0,0 +1,112 @@
+package async
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrNoResults = errors.New("no results")
+
+type Settled[T any] struct {
+	Res T
+	Err error
+}
+
+type Errors []error
+
+func (es Errors) Error() string {
+	s := make([]string, 0, len(es))
+	for _, e := range es {
+		s = append(s, e.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// resultsを並列に待ち、完了した順にindexを流す
+func settle[T any](results []Result[T]) <-chan indexed[T] {
+	ch := make(chan indexed[T], len(results))
+	for i := range results {
+		go func(i int) {
+			res, err := results[i].Await()
+			ch <- indexed[T]{
+				i:   i,
+				res: res,
+				err: err,
+			}
+		}(i)
+	}
+	return ch
+}
+
+type indexed[T any] struct {
+	i   int
+	res T
+	err error
+}
+
+func cancelAll[T any](results []Result[T]) {
+	for i := range results {
+		if results[i].cancel != nil {
+			results[i].cancel()
+		}
+	}
+}
+
+// 全て成功したらresultsと同じ順で値を返す
+// 1つでも失敗したら残りを待たずにそのエラーを返す
+func All[T any](results ...Result[T]) ([]T, error) {
+	ch := settle(results)
+	res := make([]T, len(results))
+	for range results {
+		r := <-ch
+		if r.err != nil {
+			cancelAll(results)
+			return nil, r.err
+		}
+		res[r.i] = r.res
+	}
+	return res, nil
+}
+
+// 最初に成功した値を返す
+// 全て失敗したらresultsと同じ順のErrorsを返す
+func Any[T any](results ...Result[T]) (res T, err error) {
+	if len(results) == 0 {
+		return res, ErrNoResults
+	}
+	ch := settle(results)
+	errs := make(Errors, len(results))
+	for range results {
+		r := <-ch
+		if r.err == nil {
+			cancelAll(results)
+			return r.res, nil
+		}
+		errs[r.i] = r.err
+	}
+	return res, errs
+}
+
+// 成功、失敗に関わらず最初に完了したものを返す
+func Race[T any](results ...Result[T]) (res T, err error) {
+	if len(results) == 0 {
+		return res, ErrNoResults
+	}
+	r := <-settle(results)
+	cancelAll(results)
+	return r.res, r.err
+}
+
+// 全ての完了を待ち、resultsと同じ順で値とエラーを返す
+func AllSettled[T any](results ...Result[T]) []Settled[T] {
+	ch := settle(results)
+	res := make([]Settled[T], len(results))
+	for range results {
+		r := <-ch
+		res[r.i] = Settled[T]{
+			Res: r.res,
+			Err: r.err,
+		}
+	}
+	return res
+}
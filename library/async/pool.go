@@ -0,0 +1,71 @@
+package async
+
+import (
+	"sync/atomic"
+)
+
+// 同時実行数を制限してGoする
+// メソッドは型パラメータを持てないのでGoPoolに渡して使う
+type Pool struct {
+	sem   chan struct{}
+	queue chan struct{}
+
+	running int64
+	queued  int64
+}
+
+// sizeは同時実行数、queueは実行待ちの数の上限
+// 実行待ちが上限に達するとGoPoolはブロックする
+// queueが負なら実行待ちの数を制限しない
+// sizeが1未満なら1にする
+func NewPool(size int, queue int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		sem: make(chan struct{}, size),
+	}
+	if queue >= 0 {
+		p.queue = make(chan struct{}, size+queue)
+	}
+	return p
+}
+
+func GoPool[T any](p *Pool, fn func() (T, error)) Result[T] {
+	r := newResult[T](nil)
+	p.submit(func() {
+		res, err := call(fn)
+		r.set(res, err)
+	})
+	return r
+}
+
+func (p *Pool) submit(fn func()) {
+	if p.queue != nil {
+		p.queue <- struct{}{}
+	}
+	atomic.AddInt64(&p.queued, 1)
+	go func() {
+		p.sem <- struct{}{}
+		atomic.AddInt64(&p.queued, -1)
+		atomic.AddInt64(&p.running, 1)
+		defer func() {
+			atomic.AddInt64(&p.running, -1)
+			<-p.sem
+			if p.queue != nil {
+				<-p.queue
+			}
+		}()
+		fn()
+	}()
+}
+
+// 実行中の数
+func (p *Pool) Running() int64 {
+	return atomic.LoadInt64(&p.running)
+}
+
+// 実行待ちの数
+func (p *Pool) Queued() int64 {
+	return atomic.LoadInt64(&p.queued)
+}
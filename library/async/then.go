@@ -0,0 +1,33 @@
+package async
+
+// rが成功したらその値でfnを実行する
+// rが失敗したらfnは実行せずにそのエラーを返す
+func Then[T any, U any](r Result[T], fn func(T) (U, error)) Result[U] {
+	next := newResult[U](r.cancel)
+	go func() {
+		v, err := r.Await()
+		if err != nil {
+			var res U
+			next.set(res, err)
+			return
+		}
+		res, err := call(func() (U, error) {
+			return fn(v)
+		})
+		next.set(res, err)
+	}()
+	return next
+}
+
+func Map[T any, U any](r Result[T], fn func(T) U) Result[U] {
+	return Then(r, func(v T) (U, error) {
+		return fn(v), nil
+	})
+}
+
+func FlatMap[T any, U any](r Result[T], fn func(T) Result[U]) Result[U] {
+	return Then(r, func(v T) (U, error) {
+		next := fn(v)
+		return next.Await()
+	})
+}
@@ -1,47 +1,419 @@
 package asyncexecute
 
 import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type AsyncExecute[V any] struct {
-	m    sync.Mutex
-	data []V
-	exec func(data []V)
+	succeeded uint64
+	failed    uint64
+	retried   uint64
+
+	partitions  []*partition[V]
+	partitionFn func(V) int
+	exec        func(data []V) error
+
+	maxBatchSize int
+	attempts     int
+	backoff      func(attempt int) time.Duration
+	deadLetter   func(data []V, err error)
+
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// 独立したバッファを持ち、それぞれのgoroutineで実行する
+type partition[V any] struct {
+	m     sync.Mutex
+	buf   buffer[V]
+	spool *spool[V]
+	// Stop後はSetされたものを捨てる
+	stopped bool
+
+	triggerCh chan struct{}
+	flushCh   chan chan struct{}
+	doneCh    chan struct{}
+}
+
+type options[V any] struct {
+	maxBatchSize int
+	attempts     int
+	backoff      func(attempt int) time.Duration
+	deadLetter   func(data []V, err error)
+	newBuffer    func(cap int, merge func(current V, v V) V) buffer[V]
+	merge        func(current V, v V) V
+	spoolDir     string
+	codec        codec[V]
+	partitionNum int
+	partitionFn  func(V) int
+}
+
+type Option[V any] func(*options[V])
+
+// execに渡す数の上限
+// Setで溜まった数が上限に達したらwaitを待たずに実行する
+func WithMaxBatchSize[V any](n int) Option[V] {
+	return func(o *options[V]) {
+		o.maxBatchSize = n
+	}
+}
+
+// execが失敗したら合計attempts回まで実行する
+// attempt回目の失敗の後、backoff(attempt)だけ待つ
+func WithRetry[V any](attempts int, backoff func(attempt int) time.Duration) Option[V] {
+	return func(o *options[V]) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+func ExponentialBackoff(base time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		return base << (attempt - 1)
+	}
+}
+
+// リトライしても失敗したデータを受け取る
+func WithDeadLetter[V any](fn func(data []V, err error)) Option[V] {
+	return func(o *options[V]) {
+		o.deadLetter = fn
+	}
+}
+
+// fnの返り値でn個のパーティションに振り分け、パーティションごとに並列で実行する
+// 同じパーティションの中ではSetした順に実行する
+// execやWithDeadLetterの関数は並列に呼ばれる
+// WithKeyと使う場合、同じキーのものは同じパーティションに振り分けないとまとめられない
+func WithPartition[V any](n int, fn func(V) int) Option[V] {
+	return func(o *options[V]) {
+		o.partitionNum = n
+		o.partitionFn = fn
+	}
+}
+
+func New[V any](exec func([]V), wait time.Duration, cap int, opts ...Option[V]) *AsyncExecute[V] {
+	return NewWithError(
+		func(data []V) error {
+			exec(data)
+			return nil
+		},
+		wait,
+		cap,
+		opts...,
+	)
 }
 
-func New[V any](exec func([]V), wait time.Duration, cap int) *AsyncExecute[V] {
+func NewWithError[V any](exec func([]V) error, wait time.Duration, cap int, opts ...Option[V]) *AsyncExecute[V] {
+	o := options[V]{
+		attempts:     1,
+		partitionNum: 1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.attempts < 1 {
+		o.attempts = 1
+	}
+	if o.partitionNum < 1 {
+		o.partitionNum = 1
+	}
+	if o.partitionNum > 1 && o.partitionFn == nil {
+		panic("asyncexecute: partition function is nil")
+	}
 	aq := &AsyncExecute[V]{
-		data: make([]V, 0, cap),
-		exec: exec,
+		partitions:   make([]*partition[V], o.partitionNum),
+		partitionFn:  o.partitionFn,
+		exec:         exec,
+		maxBatchSize: o.maxBatchSize,
+		attempts:     o.attempts,
+		backoff:      o.backoff,
+		deadLetter:   o.deadLetter,
+		stopCh:       make(chan struct{}),
+		doneCh:       make(chan struct{}),
 	}
-	go aq.execute(wait)
+	for i := range aq.partitions {
+		p := &partition[V]{
+			triggerCh: make(chan struct{}, 1),
+			flushCh:   make(chan chan struct{}),
+			doneCh:    make(chan struct{}),
+		}
+		if o.newBuffer != nil {
+			p.buf = o.newBuffer(cap, o.merge)
+		} else {
+			p.buf = &sliceBuffer[V]{data: make([]V, 0, cap)}
+		}
+		aq.partitions[i] = p
+	}
+	if o.spoolDir != "" {
+		err := aq.openSpool(o.spoolDir, o.codec)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	for _, p := range aq.partitions {
+		go aq.execute(p, wait)
+	}
+	go func() {
+		for _, p := range aq.partitions {
+			<-p.doneCh
+		}
+		close(aq.doneCh)
+	}()
 	return aq
 }
 
-func (aq *AsyncExecute[V]) Set(data ...V) {
-	aq.m.Lock()
-	aq.data = append(aq.data, data...)
-	aq.m.Unlock()
+// パーティションごとにdir/{i}を使う
+// パーティション数が変わっていても残っているものは全て振り分け直す
+func (aq *AsyncExecute[V]) openSpool(dir string, c codec[V]) error {
+	data, paths, err := readSpools(dir, c)
+	if err != nil {
+		return err
+	}
+	for i, p := range aq.partitions {
+		p.spool, err = openSpool(filepath.Join(dir, strconv.Itoa(i)), c)
+		if err != nil {
+			for _, p := range aq.partitions[:i] {
+				p.spool.close()
+				p.spool = nil
+			}
+			return err
+		}
+	}
+	aq.Set(data...)
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type buffer[V any] interface {
+	add(data ...V)
+	len() int
+	take() []V
 }
 
-func (aq *AsyncExecute[V]) get() []V {
-	aq.m.Lock()
-	data := aq.data
-	aq.data = make([]V, 0, cap(data))
-	aq.m.Unlock()
+type sliceBuffer[V any] struct {
+	data []V
+}
+
+func (b *sliceBuffer[V]) add(data ...V) {
+	b.data = append(b.data, data...)
+}
+
+func (b *sliceBuffer[V]) len() int {
+	return len(b.data)
+}
+
+func (b *sliceBuffer[V]) take() []V {
+	data := b.data
+	b.data = make([]V, 0, cap(data))
 	return data
 }
 
-func (aq *AsyncExecute[V]) execute(wait time.Duration) {
+// Stop後にSetしたものは実行されずに捨てられる
+func (aq *AsyncExecute[V]) Set(data ...V) {
+	if len(aq.partitions) == 1 {
+		aq.set(aq.partitions[0], data)
+		return
+	}
+	grouped := make([][]V, len(aq.partitions))
+	for _, v := range data {
+		i := aq.partitionFn(v) % len(aq.partitions)
+		if i < 0 {
+			i += len(aq.partitions)
+		}
+		grouped[i] = append(grouped[i], v)
+	}
+	for i, d := range grouped {
+		if len(d) > 0 {
+			aq.set(aq.partitions[i], d)
+		}
+	}
+}
+
+func (aq *AsyncExecute[V]) set(p *partition[V], data []V) {
+	p.m.Lock()
+	if p.stopped {
+		p.m.Unlock()
+		return
+	}
+	if p.spool != nil {
+		if err := p.spool.write(data); err != nil {
+			log.Println(err)
+		}
+	}
+	p.buf.add(data...)
+	full := aq.maxBatchSize > 0 && p.buf.len() >= aq.maxBatchSize
+	p.m.Unlock()
+	if full {
+		select {
+		case p.triggerCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 溜まっているものをすぐに実行し、完了するまで待つ
+func (aq *AsyncExecute[V]) Flush() {
+	dones := make([]chan struct{}, 0, len(aq.partitions))
+	for _, p := range aq.partitions {
+		done := make(chan struct{})
+		select {
+		case p.flushCh <- done:
+			dones = append(dones, done)
+		case <-p.doneCh:
+		}
+	}
+	for _, done := range dones {
+		<-done
+	}
+}
+
+// 溜まっているものを最後に実行して止める
+// ctxが先に終わった場合も実行は続くので、再度Stopを呼べば完了を待てる
+func (aq *AsyncExecute[V]) Stop(ctx context.Context) error {
+	aq.stopOnce.Do(func() {
+		close(aq.stopCh)
+	})
+	select {
+	case <-aq.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *partition[V]) retain(path string, data []V) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.spool == nil {
+		return nil
+	}
+	return p.spool.retain(path, data)
+}
+
+// spoolを使っている場合はdataが書かれているファイルのパスと、失敗したものを書くパスも返す
+func (p *partition[V]) get() (data []V, spooled string, retainPath string) {
+	p.m.Lock()
+	data = p.buf.take()
+	if p.spool != nil && len(data) > 0 {
+		var err error
+		spooled, retainPath, err = p.spool.rotate()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	p.m.Unlock()
+	return data, spooled, retainPath
+}
+
+func (aq *AsyncExecute[V]) flush(p *partition[V]) {
+	data, spooled, retainPath := p.get()
+	var failed []V
+	for len(data) > 0 {
+		n := len(data)
+		if aq.maxBatchSize > 0 && n > aq.maxBatchSize {
+			n = aq.maxBatchSize
+		}
+		// WithDeadLetterに渡したものは再実行しない
+		if !aq.call(data[:n:n]) && aq.deadLetter == nil {
+			failed = append(failed, data[:n]...)
+		}
+		data = data[n:]
+	}
+	if spooled == "" {
+		return
+	}
+	// 失敗したものだけ次のNewで再実行するので別のファイルに残しておく
+	if len(failed) > 0 {
+		if err := p.retain(retainPath, failed); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := os.Remove(spooled); err != nil {
+		log.Println(err)
+	}
+}
+
+func (aq *AsyncExecute[V]) call(data []V) bool {
+	var err error
+	for attempt := 1; attempt <= aq.attempts; attempt++ {
+		if attempt > 1 {
+			atomic.AddUint64(&aq.retried, 1)
+		}
+		err = aq.exec(data)
+		if err == nil {
+			atomic.AddUint64(&aq.succeeded, 1)
+			return true
+		}
+		if attempt < aq.attempts && aq.backoff != nil {
+			time.Sleep(aq.backoff(attempt))
+		}
+	}
+	atomic.AddUint64(&aq.failed, 1)
+	if aq.deadLetter != nil {
+		aq.deadLetter(data, err)
+	}
+	return false
+}
+
+type Stats struct {
+	// 成功したexecの数
+	Succeeded uint64
+	// リトライしても失敗したexecの数
+	Failed uint64
+	// リトライした回数
+	Retried uint64
+}
+
+func (aq *AsyncExecute[V]) Stats() Stats {
+	return Stats{
+		Succeeded: atomic.LoadUint64(&aq.succeeded),
+		Failed:    atomic.LoadUint64(&aq.failed),
+		Retried:   atomic.LoadUint64(&aq.retried),
+	}
+}
+
+func (aq *AsyncExecute[V]) execute(p *partition[V], wait time.Duration) {
+	defer close(p.doneCh)
 	c := time.NewTicker(wait)
 	defer c.Stop()
+	aq.flush(p)
 	for {
-		data := aq.get()
-		if len(data) > 0 {
-			aq.exec(data)
+		select {
+		case <-c.C:
+			aq.flush(p)
+		case <-p.triggerCh:
+			aq.flush(p)
+		case done := <-p.flushCh:
+			aq.flush(p)
+			close(done)
+		case <-aq.stopCh:
+			p.m.Lock()
+			p.stopped = true
+			p.m.Unlock()
+			aq.flush(p)
+			p.m.Lock()
+			if p.spool != nil {
+				if err := p.spool.close(); err != nil {
+					log.Println(err)
+				}
+				p.spool = nil
+			}
+			p.m.Unlock()
+			return
 		}
-		<-c.C
 	}
 }
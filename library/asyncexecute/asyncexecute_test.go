@@ -1,7 +1,12 @@
 package asyncexecute
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -57,3 +62,432 @@ func Test_AsyncExecute(t *testing.T) {
 		}
 	}
 }
+
+func Test_AsyncExecute_Stop(t *testing.T) {
+	store := map[int]User{}
+	aq := New(
+		func(us []User) {
+			time.Sleep(time.Millisecond * 10)
+			for _, u := range us {
+				store[u.ID] = u
+			}
+		},
+		time.Hour,
+		10,
+	)
+
+	for i := 0; i < 10; i++ {
+		aq.Set(newUser(i))
+	}
+	aq.Flush()
+	if len(store) != 10 {
+		t.Errorf("len(store) want %v, got %v", 10, len(store))
+	}
+
+	for i := 10; i < 20; i++ {
+		aq.Set(newUser(i))
+	}
+	err := aq.Stop(context.Background())
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if len(store) != 20 {
+		t.Errorf("len(store) want %v, got %v", 20, len(store))
+	}
+
+	// Stop後は何もしない
+	aq.Flush()
+	err = aq.Stop(context.Background())
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+}
+
+func Test_AsyncExecute_maxBatchSize(t *testing.T) {
+	m := sync.Mutex{}
+	store := map[int]User{}
+	aq := New(
+		func(us []User) {
+			if len(us) > 7 {
+				t.Errorf("len(us) want <= %v, got %v", 7, len(us))
+			}
+			m.Lock()
+			for _, u := range us {
+				store[u.ID] = u
+			}
+			m.Unlock()
+		},
+		time.Hour,
+		10,
+		WithMaxBatchSize[User](7),
+	)
+
+	users := make([]User, 0, 20)
+	for i := 0; i < 20; i++ {
+		users = append(users, newUser(i))
+	}
+	aq.Set(users...)
+	time.Sleep(time.Millisecond * 50)
+
+	m.Lock()
+	if len(store) != 20 {
+		t.Errorf("len(store) want %v, got %v", 20, len(store))
+	}
+	m.Unlock()
+	aq.Stop(context.Background())
+}
+
+func Test_AsyncExecute_retry(t *testing.T) {
+	calls := 0
+	var dead []User
+	var deadErr error
+	aq := NewWithError(
+		func(us []User) error {
+			calls++
+			if us[0].ID == 0 {
+				return errors.New("something")
+			}
+			if calls < 5 {
+				return errors.New("temporary")
+			}
+			return nil
+		},
+		time.Hour,
+		10,
+		WithRetry[User](3, ExponentialBackoff(time.Millisecond)),
+		WithDeadLetter(func(us []User, err error) {
+			dead = us
+			deadErr = err
+		}),
+	)
+
+	aq.Set(newUser(0))
+	aq.Flush()
+	if calls != 3 {
+		t.Errorf("calls want %v, got %v", 3, calls)
+	}
+	if len(dead) != 1 || dead[0].ID != 0 {
+		t.Errorf("dead want %v, got %v", []User{newUser(0)}, dead)
+	}
+	if deadErr == nil {
+		t.Errorf("deadErr want %v, got %v", errors.New("something"), deadErr)
+	}
+
+	aq.Set(newUser(1))
+	aq.Flush()
+	if calls != 5 {
+		t.Errorf("calls want %v, got %v", 5, calls)
+	}
+
+	stats := aq.Stats()
+	want := Stats{Succeeded: 1, Failed: 1, Retried: 3}
+	if stats != want {
+		t.Errorf("stats want %+v, got %+v", want, stats)
+	}
+	aq.Stop(context.Background())
+}
+
+func Test_AsyncExecute_keyed(t *testing.T) {
+	var got []User
+	aq := NewKeyed(
+		func(u User) int {
+			return u.ID
+		},
+		func(us []User) {
+			got = append(got, us...)
+		},
+		time.Hour,
+	)
+	for i := 0; i < 3; i++ {
+		for id := 0; id < 3; id++ {
+			aq.Set(User{ID: id, Name: fmt.Sprintf("name:%v", i)})
+		}
+	}
+	aq.Flush()
+	if len(got) != 3 {
+		t.Fatalf("len(got) want %v, got %v", 3, len(got))
+	}
+	for id, u := range got {
+		if u.ID != id || u.Name != "name:2" {
+			t.Errorf("got[%v] want %v, got %v", id, User{ID: id, Name: "name:2"}, u)
+		}
+	}
+	aq.Stop(context.Background())
+
+	got = nil
+	aq = NewKeyed(
+		func(u User) int {
+			return u.ID
+		},
+		func(us []User) {
+			got = append(got, us...)
+		},
+		time.Hour,
+		WithMerge(func(current User, u User) User {
+			current.Name += u.Name
+			return current
+		}),
+	)
+	aq.Set(User{ID: 1, Name: "a"}, User{ID: 1, Name: "b"})
+	aq.Flush()
+	if len(got) != 1 || got[0].Name != "ab" {
+		t.Errorf("got want %v, got %v", []User{{ID: 1, Name: "ab"}}, got)
+	}
+	aq.Stop(context.Background())
+}
+
+func (u *User) Encode() ([]byte, error) {
+	return []byte(strconv.Itoa(u.ID) + "," + u.Name), nil
+}
+
+func (u *User) Decode(in []byte) (*User, error) {
+	s := strings.SplitN(string(in), ",", 2)
+	id, err := strconv.Atoi(s[0])
+	if err != nil {
+		return nil, err
+	}
+	u.ID = id
+	u.Name = s[1]
+	return u, nil
+}
+
+func Test_AsyncExecute_spool(t *testing.T) {
+	dir := t.TempDir()
+
+	// 実行されずに落ちた想定
+	aq := New(
+		func(us []*User) {
+			t.Errorf("exec is called")
+		},
+		time.Hour,
+		10,
+		WithSpool[*User](dir),
+	)
+	aq.Flush()
+	for i := 0; i < 10; i++ {
+		u := newUser(i)
+		aq.Set(&u)
+	}
+
+	m := sync.Mutex{}
+	var got []*User
+	aq = New(
+		func(us []*User) {
+			m.Lock()
+			got = append(got, us...)
+			m.Unlock()
+		},
+		time.Hour,
+		10,
+		WithSpool[*User](dir),
+	)
+	aq.Flush()
+	m.Lock()
+	if len(got) != 10 {
+		t.Fatalf("len(got) want %v, got %v", 10, len(got))
+	}
+	for i, u := range got {
+		if *u != newUser(i) {
+			t.Errorf("got[%v] want %v, got %v", i, newUser(i), *u)
+		}
+	}
+	m.Unlock()
+
+	u := newUser(10)
+	aq.Set(&u)
+	aq.Flush()
+	aq.Stop(context.Background())
+
+	// 成功したものは再実行されない
+	got = nil
+	aq = New(
+		func(us []*User) {
+			m.Lock()
+			got = append(got, us...)
+			m.Unlock()
+		},
+		time.Hour,
+		10,
+		WithSpool[*User](dir),
+	)
+	aq.Stop(context.Background())
+	if len(got) != 0 {
+		t.Errorf("len(got) want %v, got %v", 0, len(got))
+	}
+}
+
+func Test_AsyncExecute_spool_failed(t *testing.T) {
+	replay := func(dir string) []int {
+		var got []int
+		aq := New(
+			func(us []*User) {
+				for _, u := range us {
+					got = append(got, u.ID)
+				}
+			},
+			time.Hour,
+			10,
+			WithSpool[*User](dir),
+		)
+		aq.Stop(context.Background())
+		return got
+	}
+	exec := func(us []*User) error {
+		for _, u := range us {
+			if u.ID == 2 {
+				return errors.New("something")
+			}
+		}
+		return nil
+	}
+
+	us := make([]*User, 6)
+	for i := range us {
+		u := newUser(i)
+		us[i] = &u
+	}
+
+	// 失敗したものだけ残る
+	dir := t.TempDir()
+	aq := NewWithError(exec, time.Hour, 10, WithSpool[*User](dir), WithMaxBatchSize[*User](2))
+	aq.Flush()
+	aq.Set(us...)
+	aq.Stop(context.Background())
+	// Stop後のSetはspoolに書かない
+	u := newUser(6)
+	aq.Set(&u)
+	got := replay(dir)
+	if fmt.Sprint(got) != "[2 3]" {
+		t.Errorf("got want %v, got %v", "[2 3]", got)
+	}
+
+	// WithDeadLetterに渡したものは残らない
+	dir = t.TempDir()
+	var dead []int
+	aq = NewWithError(exec, time.Hour, 10, WithSpool[*User](dir), WithMaxBatchSize[*User](2),
+		WithDeadLetter(func(us []*User, err error) {
+			for _, u := range us {
+				dead = append(dead, u.ID)
+			}
+		}),
+	)
+	aq.Flush()
+	aq.Set(us...)
+	aq.Stop(context.Background())
+	if fmt.Sprint(dead) != "[2 3]" {
+		t.Errorf("dead want %v, got %v", "[2 3]", dead)
+	}
+	got = replay(dir)
+	if len(got) != 0 {
+		t.Errorf("len(got) want %v, got %v", 0, len(got))
+	}
+}
+
+func Test_AsyncExecute_spool_failedOrder(t *testing.T) {
+	dir := t.TempDir()
+	key := WithKey(func(u *User) int {
+		return u.ID
+	})
+
+	// 失敗した後に同じキーで新しくSetしてから落ちた想定
+	aq := NewWithError(
+		func(us []*User) error {
+			return errors.New("something")
+		},
+		time.Hour,
+		10,
+		key,
+		WithSpool[*User](dir),
+	)
+	aq.Flush()
+	aq.Set(&User{ID: 1, Name: "old"})
+	aq.Flush()
+	aq.Set(&User{ID: 1, Name: "new"})
+
+	var got []User
+	aq = New(
+		func(us []*User) {
+			for _, u := range us {
+				got = append(got, *u)
+			}
+		},
+		time.Hour,
+		10,
+		key,
+		WithSpool[*User](dir),
+	)
+	aq.Stop(context.Background())
+	if len(got) != 1 || got[0].Name != "new" {
+		t.Errorf("got want %v, got %v", []User{{ID: 1, Name: "new"}}, got)
+	}
+}
+
+func Test_AsyncExecute_partition(t *testing.T) {
+	m := sync.Mutex{}
+	got := map[int][]int{}
+	aq := New(
+		func(us []User) {
+			time.Sleep(time.Millisecond * 20)
+			m.Lock()
+			for _, u := range us {
+				got[u.ID%4] = append(got[u.ID%4], u.ID)
+			}
+			m.Unlock()
+		},
+		time.Hour,
+		10,
+		WithPartition(4, func(u User) int {
+			return u.ID
+		}),
+		WithMaxBatchSize[User](5),
+	)
+
+	start := time.Now()
+	for i := 0; i < 40; i++ {
+		aq.Set(newUser(i))
+	}
+	aq.Stop(context.Background())
+	// 各パーティションで2回ずつ並列に実行される
+	if d := time.Since(start); d > time.Millisecond*120 {
+		t.Errorf("partitions are not executed in parallel: %v", d)
+	}
+
+	for p := 0; p < 4; p++ {
+		ids := got[p]
+		if len(ids) != 10 {
+			t.Errorf("len(got[%v]) want %v, got %v", p, 10, len(ids))
+		}
+		for i, id := range ids {
+			if id != i*4+p {
+				t.Errorf("got[%v][%v] want %v, got %v", p, i, i*4+p, id)
+			}
+		}
+	}
+}
+
+func Test_AsyncExecute_partition_nil(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("New does not panic")
+		}
+	}()
+	New(func(us []User) {}, time.Hour, 10, WithPartition[User](4, nil))
+}
+
+func Test_AsyncExecute_setAfterStop(t *testing.T) {
+	aq := New(func(us []User) {}, time.Hour, 10, WithPartition(2, func(u User) int {
+		return u.ID
+	}))
+	aq.Stop(context.Background())
+	for i := 0; i < 100; i++ {
+		aq.Set(newUser(i))
+	}
+	for i, p := range aq.partitions {
+		p.m.Lock()
+		n := p.buf.len()
+		p.m.Unlock()
+		if n != 0 {
+			t.Errorf("partitions[%v] len want %v, got %v", i, 0, n)
+		}
+	}
+}
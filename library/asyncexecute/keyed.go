@@ -0,0 +1,67 @@
+package asyncexecute
+
+import "time"
+
+// 同じキーのものは1つにまとめる
+// 順番は最初にSetされた順になる
+type keyedBuffer[K comparable, V any] struct {
+	keyFn func(V) K
+	merge func(current V, v V) V
+	index map[K]int
+	data  []V
+}
+
+func (b *keyedBuffer[K, V]) add(data ...V) {
+	for _, v := range data {
+		k := b.keyFn(v)
+		i, ok := b.index[k]
+		if !ok {
+			b.index[k] = len(b.data)
+			b.data = append(b.data, v)
+			continue
+		}
+		if b.merge != nil {
+			b.data[i] = b.merge(b.data[i], v)
+		} else {
+			b.data[i] = v
+		}
+	}
+}
+
+func (b *keyedBuffer[K, V]) len() int {
+	return len(b.data)
+}
+
+func (b *keyedBuffer[K, V]) take() []V {
+	data := b.data
+	b.data = make([]V, 0, cap(data))
+	b.index = make(map[K]int, cap(data))
+	return data
+}
+
+// keyFnが同じキーを返すものは実行前に1つにまとめる
+// デフォルトでは後からSetしたもので上書きする
+// まとめるのはパーティションごとなので、WithPartitionでは同じキーを同じパーティションに振り分ける
+func WithKey[K comparable, V any](keyFn func(V) K) Option[V] {
+	return func(o *options[V]) {
+		o.newBuffer = func(cap int, merge func(V, V) V) buffer[V] {
+			return &keyedBuffer[K, V]{
+				keyFn: keyFn,
+				merge: merge,
+				index: make(map[K]int, cap),
+				data:  make([]V, 0, cap),
+			}
+		}
+	}
+}
+
+// WithKeyで同じキーのものをまとめる関数
+func WithMerge[V any](merge func(current V, v V) V) Option[V] {
+	return func(o *options[V]) {
+		o.merge = merge
+	}
+}
+
+func NewKeyed[K comparable, V any](keyFn func(V) K, exec func([]V), wait time.Duration, opts ...Option[V]) *AsyncExecute[V] {
+	return New(exec, wait, 64, append([]Option[V]{WithKey(keyFn)}, opts...)...)
+}
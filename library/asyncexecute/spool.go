@@ -0,0 +1,248 @@
+package asyncexecute
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encodegenで生成したEncode, Decodeを持つ型
+type Encoder[V any] interface {
+	Encode() ([]byte, error)
+	Decode(in []byte) (V, error)
+}
+
+type codec[V any] struct {
+	encode func(V) ([]byte, error)
+	decode func([]byte) (V, error)
+}
+
+// Setしたものをdirのファイルに追記し、execが成功したら消す
+// Newの時にdirに残っているものは再度Setされる
+// 失敗したものはWithDeadLetterを使っていなければ残す
+func WithSpool[V Encoder[V]](dir string) Option[V] {
+	return func(o *options[V]) {
+		o.spoolDir = dir
+		o.codec = codec[V]{
+			encode: func(v V) ([]byte, error) {
+				return v.Encode()
+			},
+			decode: func(in []byte) (V, error) {
+				var v V
+				// ポインタレシーバのDecodeはnilだと使えない
+				if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Pointer {
+					v = reflect.New(t.Elem()).Interface().(V)
+				}
+				return v.Decode(in)
+			},
+		}
+	}
+}
+
+const spoolExt = ".spool"
+
+type spool[V any] struct {
+	dir   string
+	codec codec[V]
+	// 最後に使った番号
+	seq int
+	f   *os.File
+	cur string
+}
+
+// dirとその直下のディレクトリに残っているものを読み込む
+func readSpools[V any](dir string, c codec[V]) (data []V, paths []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	dirs := []string{dir}
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, e.Name()))
+		}
+	}
+	for _, d := range dirs {
+		seqs, err := spoolSeqs(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := &spool[V]{
+			dir:   d,
+			codec: c,
+		}
+		for _, seq := range seqs {
+			path := s.path(seq)
+			v, err := s.read(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = append(data, v...)
+			paths = append(paths, path)
+		}
+	}
+	return data, paths, nil
+}
+
+func spoolSeqs(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]int, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, spoolExt))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// 残っているファイルより後の番号で新しいファイルを作る
+func openSpool[V any](dir string, c codec[V]) (*spool[V], error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := spoolSeqs(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool[V]{
+		dir:   dir,
+		codec: c,
+	}
+	if len(seqs) > 0 {
+		s.seq = seqs[len(seqs)-1]
+	}
+	err = s.open()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *spool[V]) path(seq int) string {
+	return filepath.Join(s.dir, strconv.Itoa(seq)+spoolExt)
+}
+
+func (s *spool[V]) next() string {
+	s.seq++
+	return s.path(s.seq)
+}
+
+func (s *spool[V]) open() error {
+	path := s.next()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.f, s.cur = f, path
+	return nil
+}
+
+// 書き込み途中で落ちた最後のレコードは無視する
+func (s *spool[V]) read(path string) ([]V, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var data []V
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return data, nil
+			}
+			return nil, err
+		}
+		b := make([]byte, size)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return data, nil
+			}
+			return nil, err
+		}
+		v, err := s.codec.decode(b)
+		if err != nil {
+			return nil, fmt.Errorf("decode %v: %w", path, err)
+		}
+		data = append(data, v)
+	}
+}
+
+func (s *spool[V]) write(data []V) error {
+	out, err := s.encode(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(out)
+	return err
+}
+
+func (s *spool[V]) encode(data []V) ([]byte, error) {
+	var out []byte
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, v := range data {
+		b, err := s.codec.encode(v)
+		if err != nil {
+			return nil, err
+		}
+		n := binary.PutUvarint(size, uint64(len(b)))
+		out = append(out, size[:n]...)
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// rotateで予約したpathにdataを書く
+// rotateで返されないので、次のNewまで残る
+func (s *spool[V]) retain(path string, data []V) error {
+	out, err := s.encode(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+// 以降の書き込みを新しいファイルに切り替え、それまでのファイルのパスを返す
+// retainPathはprevのdataのうち失敗したものを書くパスで、以降の書き込みより前に再実行されるように先に番号を取っておく
+func (s *spool[V]) rotate() (prev string, retainPath string, err error) {
+	err = s.f.Close()
+	if err != nil {
+		return "", "", err
+	}
+	prev = s.cur
+	retainPath = s.next()
+	err = s.open()
+	if err != nil {
+		return "", "", err
+	}
+	return prev, retainPath, nil
+}
+
+func (s *spool[V]) close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,110 @@
+package bulkexecute
+
+import (
+	"math"
+	"time"
+)
+
+// 負荷に応じてwaitをmin〜maxの間で調整する
+// 渡したwaitは初期値になる
+func WithAdaptiveWait(min time.Duration, max time.Duration) Option {
+	return func(o *options) {
+		o.minWait = min
+		o.maxWait = max
+	}
+}
+
+type adaptive struct {
+	min      time.Duration
+	max      time.Duration
+	window   time.Duration
+	lastTake time.Time
+}
+
+func newAdaptive(wait time.Duration, min time.Duration, max time.Duration) *adaptive {
+	a := &adaptive{
+		min:      min,
+		max:      max,
+		window:   wait,
+		lastTake: time.Now(),
+	}
+	a.window = a.clamp(a.window)
+	return a
+}
+
+func (a *adaptive) clamp(d time.Duration) time.Duration {
+	if d < a.min {
+		return a.min
+	}
+	if d > a.max {
+		return a.max
+	}
+	return d
+}
+
+// 待ってもexec1回分の間に1件しか来ない程度の負荷なら待つだけ無駄なので縮める
+// そうでなければexecにかかる時間に近づける
+// execが遅いほどまとめる効果が大きく、実行中にも次の分が溜まるので待ち時間が増えても気にならない
+// takenはdataを取り出した時刻
+func (a *adaptive) update(n int, taken time.Time, latency time.Duration) {
+	elapsed := taken.Sub(a.lastTake)
+	a.lastTake = taken
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(n) / float64(elapsed)
+	if rate*float64(a.window+latency) < 2 {
+		a.window = a.clamp(a.window / 2)
+		return
+	}
+	a.window = a.clamp(a.window + (latency-a.window)/4)
+}
+
+// 現在のwait
+func (be *BulkExecute[V, R]) Window() time.Duration {
+	be.m.Lock()
+	defer be.m.Unlock()
+	if be.adaptive != nil {
+		return be.adaptive.window
+	}
+	return be.wait
+}
+
+type Bucket struct {
+	// 件数がこれ以下のバッチの数
+	// 最後のバケットはmath.MaxInt
+	Le    int
+	Count uint64
+}
+
+// 1, 2, 4, ... 1024, それ以上
+const bucketNum = 12
+
+type histogram [bucketNum]uint64
+
+func (h *histogram) add(n int) {
+	i := 0
+	for le := 1; i < bucketNum-1 && n > le; le <<= 1 {
+		i++
+	}
+	h[i]++
+}
+
+func (h *histogram) buckets() []Bucket {
+	bs := make([]Bucket, bucketNum)
+	for i := range bs {
+		bs[i] = Bucket{
+			Le:    1 << i,
+			Count: h[i],
+		}
+	}
+	bs[bucketNum-1].Le = math.MaxInt
+	return bs
+}
+
+// execに渡したdataの数の分布
+func (be *BulkExecute[V, R]) BatchSizes() []Bucket {
+	be.m.Lock()
+	defer be.m.Unlock()
+	return be.batchSizes.buckets()
+}
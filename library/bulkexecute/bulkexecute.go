@@ -1,6 +1,7 @@
 package bulkexecute
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -10,65 +11,287 @@ const execNum = 3
 type BulkExecute[V any, R any] struct {
 	m sync.Mutex
 
-	chans [execNum]chan struct{}
-	wgs   [execNum]sync.WaitGroup
+	execNum int
+	chans   []chan struct{}
+	wgs     []sync.WaitGroup
+	// 上限に達して締め切ったdata
+	sealed      [][]V
+	sealedMetas []batchMeta
+	fulls       []chan struct{}
 
-	exec func(data []V) (R, error)
-	wait time.Duration
+	exec         func(data []V) (R, error)
+	wait         time.Duration
+	maxBatchSize int
+	adaptive     *adaptive
+	batchSizes   histogram
+	observer     Observer
 
-	data  []V
+	data []V
+	meta batchMeta
+	// 何世代目のdataを溜めているか
 	count uint64
+	// これより前の世代はleadが終わっている
+	finished uint64
+	// countかfinishedが変わったら閉じる
+	changed chan struct{}
 
 	res R
 	err error
 }
 
-func New[V any, R any](exec func([]V) (R, error), wait time.Duration, cap int) *BulkExecute[V, R] {
-	initCh := make(chan struct{}, 1)
-	initCh <- struct{}{}
-	return &BulkExecute[V, R]{
-		chans: [execNum]chan struct{}{
-			initCh,
-			make(chan struct{}, 1),
-			make(chan struct{}, 1),
-		},
-		exec: exec,
-		wait: wait,
-		data: make([]V, 0, cap),
+type options struct {
+	execNum      int
+	maxBatchSize int
+	minWait      time.Duration
+	maxWait      time.Duration
+	observer     Observer
+}
+
+type Option func(*options)
+
+// 同時に存在できる世代の数
+// 3未満は3になる
+func WithExecNum(n int) Option {
+	return func(o *options) {
+		o.execNum = n
+	}
+}
+
+// 溜まった数が上限に達したらwaitを待たずに実行する
+// 上限を超える場合は次の世代に溜め、次の世代に切り替えられなければ切り替えられるまで待つ
+// 1回で渡したdataが上限を超える場合はそれだけで実行する
+func WithMaxBatchSize(n int) Option {
+	return func(o *options) {
+		o.maxBatchSize = n
+	}
+}
+
+func New[V any, R any](exec func([]V) (R, error), wait time.Duration, cap int, opts ...Option) *BulkExecute[V, R] {
+	o := options{
+		execNum: execNum,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.execNum < execNum {
+		o.execNum = execNum
+	}
+	be := &BulkExecute[V, R]{
+		execNum:      o.execNum,
+		chans:        make([]chan struct{}, o.execNum),
+		wgs:          make([]sync.WaitGroup, o.execNum),
+		sealed:       make([][]V, o.execNum),
+		sealedMetas:  make([]batchMeta, o.execNum),
+		fulls:        make([]chan struct{}, o.execNum),
+		exec:         exec,
+		wait:         wait,
+		maxBatchSize: o.maxBatchSize,
+		observer:     o.observer,
+		data:         make([]V, 0, cap),
+		changed:      make(chan struct{}),
+	}
+	if o.maxWait > 0 {
+		be.adaptive = newAdaptive(wait, o.minWait, o.maxWait)
+	}
+	for i := range be.chans {
+		be.chans[i] = make(chan struct{}, 1)
+		be.fulls[i] = make(chan struct{}, 1)
 	}
+	be.chans[0] <- struct{}{}
+	return be
 }
 
 func (be *BulkExecute[V, R]) Execute(data ...V) (R, error) {
+	return be.ExecuteContext(context.Background(), data...)
+}
+
+// ctxが終わったら結果を待たずにctx.Err()を返す
+// 渡したdataはキャンセルしても他のものと一緒に実行される
+// WithMaxBatchSizeで世代の切り替えを待っている間にキャンセルした場合は実行されない
+func (be *BulkExecute[V, R]) ExecuteContext(ctx context.Context, data ...V) (res R, err error) {
+	res, _, err = be.execute(ctx, data)
+	return res, err
+}
+
+func (be *BulkExecute[V, R]) index(count uint64) uint64 {
+	return count % uint64(be.execNum)
+}
+
+// offsetはexecに渡すdataの中で、渡したdataが始まる位置
+func (be *BulkExecute[V, R]) execute(ctx context.Context, data []V) (res R, offset int, err error) {
 	be.m.Lock()
+	// 入れると上限を超える場合は締め切るか、締め切れるようになるまで待つ
+	for be.maxBatchSize > 0 && len(be.data) > 0 && len(be.data)+len(data) > be.maxBatchSize {
+		if be.seal() {
+			break
+		}
+		be.full(be.index(be.count))
+		changed := be.changed
+		be.m.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return res, 0, ctx.Err()
+		}
+		be.m.Lock()
+	}
+	offset = len(be.data)
 	be.data = append(be.data, data...)
-	current := be.count
+	count := be.count
+	current := be.index(count)
+	ch := be.chans[current]
+	be.wgs[current].Add(1)
+	if be.observer != nil {
+		be.meta.join(time.Now())
+	}
+	if be.maxBatchSize > 0 && len(be.data) >= be.maxBatchSize {
+		if !be.seal() {
+			be.full(current)
+		}
+	}
 	be.m.Unlock()
 
-	be.wgs[current].Add(1)
 	defer be.wgs[current].Done()
 
-	_, ok := <-be.chans[current]
-	if !ok {
-		return be.res, be.err
+	// 最初に受け取ったものがまとめて実行する
+	// 全員キャンセルした場合は次に来たものが受け取る
+	select {
+	case _, ok := <-ch:
+		if !ok {
+			return be.res, offset, be.err
+		}
+	case <-ctx.Done():
+		be.handOver(count, ch)
+		return res, offset, ctx.Err()
 	}
 
-	time.Sleep(be.wait)
+	// 呼び出し元がキャンセルしても後続が詰まらないように別のgoroutineで実行する
+	go be.lead(count)
 
-	next := (current + 1) % execNum
+	select {
+	case <-ch:
+		return be.res, offset, be.err
+	case <-ctx.Done():
+		return res, offset, ctx.Err()
+	}
+}
+
+// ロック中に呼ぶ
+// 次の世代の場所が空いていれば締め切って以降は次の世代に溜める
+// lead(finished)はfinished-1の世代の場所が空くのを待つので、その場所は使わない
+func (be *BulkExecute[V, R]) seal() bool {
+	if be.count+1 >= be.finished+uint64(be.execNum)-1 {
+		return false
+	}
+	current := be.index(be.count)
+	be.sealed[current] = be.data
+	be.sealedMetas[current] = be.meta
+	be.data = make([]V, 0, cap(be.data))
+	be.meta = batchMeta{}
+	be.count++
+	be.notify()
+	be.full(current)
+	return true
+}
+
+// ロック中に呼ぶ
+// leadがwaitを待たずに実行するようにする
+func (be *BulkExecute[V, R]) full(current uint64) {
+	select {
+	case be.fulls[current] <- struct{}{}:
+	default:
+	}
+}
+
+// ロック中に呼ぶ
+func (be *BulkExecute[V, R]) notify() {
+	close(be.changed)
+	be.changed = make(chan struct{})
+}
+
+// 締め切られた世代には後から誰も来ないので、キャンセルしたものが受け取れれば代わりに実行する
+func (be *BulkExecute[V, R]) handOver(count uint64, ch chan struct{}) {
+	be.m.Lock()
+	defer be.m.Unlock()
+	if be.count == count {
+		return
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			go be.lead(count)
+		}
+	default:
+	}
+}
+
+func (be *BulkExecute[V, R]) lead(count uint64) {
+	current := be.index(count)
 
 	be.m.Lock()
-	d := be.data
-	be.data = make([]V, 0, cap(d))
-	be.count = next
+	sealed := be.sealed[current] != nil
+	wait := be.wait
+	if be.adaptive != nil {
+		wait = be.adaptive.window
+	}
+	be.m.Unlock()
+	if !sealed {
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-be.fulls[current]:
+			t.Stop()
+		}
+	}
+
+	be.m.Lock()
+	var d []V
+	var meta batchMeta
+	if be.count == count {
+		d = be.data
+		meta = be.meta
+		be.data = make([]V, 0, cap(d))
+		be.meta = batchMeta{}
+		be.count++
+		be.notify()
+	} else {
+		d = be.sealed[current]
+		meta = be.sealedMetas[current]
+		be.sealed[current] = nil
+		be.sealedMetas[current] = batchMeta{}
+	}
+	select {
+	case <-be.fulls[current]:
+	default:
+	}
+	be.batchSizes.add(len(d))
 	be.m.Unlock()
 
+	taken := time.Now()
 	res, err := be.exec(d)
-	be.wgs[(current+execNum-1)%execNum].Wait()
+	latency := time.Since(taken)
+	if be.adaptive != nil {
+		be.m.Lock()
+		be.adaptive.update(len(d), taken, latency)
+		be.m.Unlock()
+	}
+	be.wgs[be.index(count+uint64(be.execNum)-1)].Wait()
 	be.res, be.err = res, err
 
+	be.m.Lock()
+	be.finished = count + 1
+	be.notify()
 	close(be.chans[current])
 	be.chans[current] = make(chan struct{}, 1)
-	be.chans[next] <- struct{}{}
+	// 次の世代が締め切られていれば全員キャンセルしているかもしれないので受け取りを待たずに実行する
+	if count+1 < be.count {
+		go be.lead(count + 1)
+	} else {
+		be.chans[be.index(count+1)] <- struct{}{}
+	}
+	be.m.Unlock()
 
-	return res, err
+	if be.observer != nil {
+		be.observer.ObserveBatch(meta.stat(len(d), time.Now(), latency))
+	}
 }
@@ -1,6 +1,8 @@
 package bulkexecute
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -89,3 +91,417 @@ func Test_BulkExecute(t *testing.T) {
 		t.Errorf("len(store) want %v, got %v", 5000, len(store))
 	}
 }
+
+func Test_BulkExecute_ExecuteContext(t *testing.T) {
+	be := New(
+		func(us []User) ([]User, error) {
+			time.Sleep(time.Millisecond * 50)
+			return us, nil
+		},
+		time.Millisecond*10,
+		10,
+	)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			if i%2 == 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Millisecond*20)
+				defer cancel()
+			}
+			start := time.Now()
+			res, err := be.ExecuteContext(ctx, newUser(i))
+			if i%2 == 0 {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("err want %v, got %v", context.DeadlineExceeded, err)
+				}
+				if time.Since(start) > time.Millisecond*40 {
+					t.Errorf("canceled caller is not returned immediately")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("err want %v, got %v", nil, err)
+			}
+			exist := false
+			for _, u := range res {
+				if u.ID == i {
+					exist = true
+				}
+			}
+			if !exist {
+				t.Errorf("u.ID %v does not exist in response", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 5; i++ {
+		res, err := be.Execute(newUser(i))
+		if err != nil {
+			t.Errorf("err want %v, got %v", nil, err)
+		}
+		if len(res) != 1 || res[0].ID != i {
+			t.Errorf("res want %v, got %v", []User{newUser(i)}, res)
+		}
+	}
+}
+
+func Test_BulkExecuteEach(t *testing.T) {
+	be := NewEach(
+		func(ids []int) ([]User, []error) {
+			us := make([]User, len(ids))
+			var errs []error
+			for i, id := range ids {
+				if id < 0 {
+					if errs == nil {
+						errs = make([]error, len(ids))
+					}
+					errs[i] = errors.New("invalid id")
+					continue
+				}
+				us[i] = newUser(id)
+			}
+			return us, errs
+		},
+		time.Millisecond*10,
+		10,
+	)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids := []int{i * 2, i*2 + 1}
+			if i%10 == 0 {
+				ids[1] = -1
+			}
+			res, errs := be.Execute(ids...)
+			if len(res) != 2 {
+				t.Errorf("len(res) want %v, got %v", 2, len(res))
+				return
+			}
+			if res[0].ID != i*2 {
+				t.Errorf("res[0].ID want %v, got %v", i*2, res[0].ID)
+			}
+			if i%10 == 0 {
+				if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+					t.Errorf("errs want [nil, error], got %v", errs)
+				}
+				return
+			}
+			if errs != nil {
+				t.Errorf("errs want %v, got %v", nil, errs)
+			}
+			if res[1].ID != i*2+1 {
+				t.Errorf("res[1].ID want %v, got %v", i*2+1, res[1].ID)
+			}
+		}(i)
+		time.Sleep(time.Millisecond * time.Duration(rand.Intn(3)))
+	}
+	wg.Wait()
+}
+
+func Test_BulkExecute_maxBatchSize(t *testing.T) {
+	m := sync.Mutex{}
+	sizes := []int{}
+	be := New(
+		func(us []User) ([]User, error) {
+			m.Lock()
+			sizes = append(sizes, len(us))
+			m.Unlock()
+			time.Sleep(time.Millisecond * 5)
+			return us, nil
+		},
+		time.Second,
+		10,
+		WithExecNum(5),
+		WithMaxBatchSize(10),
+	)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := be.Execute(newUser(i))
+			if err != nil {
+				t.Errorf("err want %v, got %v", nil, err)
+			}
+			exist := false
+			for _, u := range res {
+				if u.ID == i {
+					exist = true
+				}
+			}
+			if !exist {
+				t.Errorf("u.ID %v does not exist in response", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if d := time.Since(start); d > time.Millisecond*500 {
+		t.Errorf("batch is not executed early: %v", d)
+	}
+	total := 0
+	for _, size := range sizes {
+		if size > 10 {
+			t.Errorf("size want <= %v, got %v", 10, size)
+		}
+		total += size
+	}
+	if total != 30 {
+		t.Errorf("total want %v, got %v", 30, total)
+	}
+}
+
+func Test_BulkExecute_maxBatchSize_slow(t *testing.T) {
+	m := sync.Mutex{}
+	sizes := []int{}
+	be := New(
+		func(us []User) ([]User, error) {
+			m.Lock()
+			sizes = append(sizes, len(us))
+			m.Unlock()
+			time.Sleep(time.Millisecond * 20)
+			return us, nil
+		},
+		time.Millisecond*5,
+		10,
+		WithMaxBatchSize(10),
+	)
+
+	// execが遅い間も呼び出しが続く
+	wg := sync.WaitGroup{}
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := be.Execute(newUser(i))
+			if err != nil {
+				t.Errorf("err want %v, got %v", nil, err)
+			}
+			exist := false
+			for _, u := range res {
+				if u.ID == i {
+					exist = true
+				}
+			}
+			if !exist {
+				t.Errorf("u.ID %v does not exist in response", i)
+			}
+		}(i)
+		time.Sleep(time.Millisecond / 2)
+	}
+	wg.Wait()
+	total := 0
+	for _, size := range sizes {
+		if size > 10 {
+			t.Errorf("size want <= %v, got %v", 10, size)
+		}
+		total += size
+	}
+	if total != 200 {
+		t.Errorf("total want %v, got %v", 200, total)
+	}
+}
+
+func Test_BulkExecute_maxBatchSize_seal(t *testing.T) {
+	be := New(
+		func(us []User) ([]User, error) {
+			time.Sleep(time.Millisecond * 50)
+			return us, nil
+		},
+		time.Millisecond*10,
+		10,
+		WithMaxBatchSize(1),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := be.Execute(newUser(i))
+				if err != nil {
+					t.Errorf("err want %v, got %v", nil, err)
+				}
+			}(i)
+			time.Sleep(time.Millisecond * 5)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Execute does not return")
+	}
+}
+
+func Test_BulkExecute_maxBatchSize_cancel(t *testing.T) {
+	be := New(
+		func(us []User) ([]User, error) {
+			time.Sleep(time.Millisecond * 50)
+			return us, nil
+		},
+		time.Millisecond*10,
+		10,
+		WithExecNum(4),
+		WithMaxBatchSize(2),
+	)
+
+	// 1つ目の世代を実行中に2つ目の世代を締め切り、全員キャンセルする
+	go be.Execute(newUser(0), newUser(1))
+	time.Sleep(time.Millisecond * 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	for i := 2; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := be.ExecuteContext(ctx, newUser(i))
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("err want %v, got %v", context.Canceled, err)
+			}
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 5)
+	cancel()
+	wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res, err := be.Execute(newUser(4))
+		if err != nil {
+			t.Errorf("err want %v, got %v", nil, err)
+		}
+		if len(res) != 1 || res[0].ID != 4 {
+			t.Errorf("res want %v, got %v", []User{newUser(4)}, res)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Execute does not return")
+	}
+}
+
+func Test_BulkExecute_adaptiveWait(t *testing.T) {
+	be := New(
+		func(us []User) ([]User, error) {
+			return us, nil
+		},
+		time.Millisecond*40,
+		10,
+		WithAdaptiveWait(time.Millisecond, time.Millisecond*100),
+	)
+
+	// 1件ずつしか来ないので縮む
+	for i := 0; i < 10; i++ {
+		be.Execute(newUser(i))
+	}
+	if w := be.Window(); w != time.Millisecond {
+		t.Errorf("window want %v, got %v", time.Millisecond, w)
+	}
+
+	total := uint64(0)
+	for _, b := range be.BatchSizes() {
+		if b.Le == 1 && b.Count != 10 {
+			t.Errorf("batch size 1 count want %v, got %v", 10, b.Count)
+		}
+		total += b.Count
+	}
+	if total != 10 {
+		t.Errorf("total want %v, got %v", 10, total)
+	}
+
+	be = New(
+		func(us []User) ([]User, error) {
+			time.Sleep(time.Millisecond * 30)
+			return us, nil
+		},
+		time.Millisecond,
+		10,
+		WithAdaptiveWait(time.Millisecond, time.Millisecond*100),
+	)
+
+	// 大量に来てexecが遅いので伸びる
+	wg := sync.WaitGroup{}
+	var w time.Duration
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func(i int) {
+			be.Execute(newUser(i))
+			wg.Done()
+		}(i)
+		time.Sleep(time.Millisecond / 2)
+		if i == 400 {
+			w = be.Window()
+		}
+	}
+	wg.Wait()
+	if w <= time.Millisecond*5 {
+		t.Errorf("window want > %v, got %v", time.Millisecond*5, w)
+	}
+}
+
+type testObserver struct {
+	m     sync.Mutex
+	stats []BatchStat
+}
+
+func (o *testObserver) ObserveBatch(s BatchStat) {
+	o.m.Lock()
+	o.stats = append(o.stats, s)
+	o.m.Unlock()
+}
+
+func Test_BulkExecute_observer(t *testing.T) {
+	o := &testObserver{}
+	be := New(
+		func(us []User) ([]User, error) {
+			time.Sleep(time.Millisecond * 10)
+			return us, nil
+		},
+		time.Millisecond*20,
+		10,
+		WithObserver(o),
+	)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			be.Execute(newUser(i*2), newUser(i*2+1))
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond * 10)
+
+	o.m.Lock()
+	defer o.m.Unlock()
+	if len(o.stats) != 1 {
+		t.Fatalf("len(o.stats) want %v, got %v", 1, len(o.stats))
+	}
+	s := o.stats[0]
+	if s.Items != 10 || s.Callers != 5 {
+		t.Errorf("s.Items, s.Callers want %v, %v, got %v, %v", 10, 5, s.Items, s.Callers)
+	}
+	if s.Exec < time.Millisecond*10 {
+		t.Errorf("s.Exec want >= %v, got %v", time.Millisecond*10, s.Exec)
+	}
+	if s.MaxWait < time.Millisecond*30 || s.MeanWait > s.MaxWait {
+		t.Errorf("s.MeanWait, s.MaxWait want <= %v, >= %v, got %v, %v", s.MaxWait, time.Millisecond*30, s.MeanWait, s.MaxWait)
+	}
+}
@@ -0,0 +1,72 @@
+package bulkexecute
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrResultLength = errors.New("result length does not match data length")
+
+// execはdataと同じ順、同じ数の結果を返す
+// 呼び出し元は自分が渡したdataの分の結果だけを受け取る
+type BulkExecuteEach[V any, R any] struct {
+	be *BulkExecute[V, eachResult[R]]
+}
+
+type eachResult[R any] struct {
+	res  []R
+	errs []error
+}
+
+// execが返すerrsは全て成功した場合はnilでよい
+func NewEach[V any, R any](exec func([]V) ([]R, []error), wait time.Duration, cap int, opts ...Option) *BulkExecuteEach[V, R] {
+	return &BulkExecuteEach[V, R]{
+		be: New(
+			func(data []V) (eachResult[R], error) {
+				res, errs := exec(data)
+				if len(res) != len(data) || (errs != nil && len(errs) != len(data)) {
+					return eachResult[R]{}, ErrResultLength
+				}
+				return eachResult[R]{
+					res:  res,
+					errs: errs,
+				}, nil
+			},
+			wait,
+			cap,
+			opts...,
+		),
+	}
+}
+
+func (be *BulkExecuteEach[V, R]) Execute(data ...V) ([]R, []error) {
+	return be.ExecuteContext(context.Background(), data...)
+}
+
+// errsはdataと同じ順で、全て成功した場合はnil
+func (be *BulkExecuteEach[V, R]) ExecuteContext(ctx context.Context, data ...V) (res []R, errs []error) {
+	r, offset, err := be.be.execute(ctx, data)
+	if err != nil {
+		errs = make([]error, len(data))
+		for i := range errs {
+			errs[i] = err
+		}
+		return nil, errs
+	}
+	end := offset + len(data)
+	res = r.res[offset:end:end]
+	if r.errs == nil {
+		return res, nil
+	}
+	for i, err := range r.errs[offset:end] {
+		if err == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make([]error, len(data))
+		}
+		errs[i] = err
+	}
+	return res, errs
+}
@@ -0,0 +1,54 @@
+package bulkexecute
+
+import "time"
+
+type BatchStat struct {
+	// execに渡したdataの数
+	Items int
+	// Executeを呼んだ数
+	Callers int
+	// 呼び出し元がExecuteを呼んでから結果を受け取れるようになるまでの時間
+	MeanWait time.Duration
+	MaxWait  time.Duration
+	// execにかかった時間
+	Exec time.Duration
+}
+
+// execごとに呼ばれる
+type Observer interface {
+	ObserveBatch(s BatchStat)
+}
+
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+type batchMeta struct {
+	callers int
+	first   time.Time
+	// 各呼び出しのfirstからの経過時間の合計
+	offsetSum time.Duration
+}
+
+func (m *batchMeta) join(now time.Time) {
+	if m.callers == 0 {
+		m.first = now
+	}
+	m.callers++
+	m.offsetSum += now.Sub(m.first)
+}
+
+func (m *batchMeta) stat(items int, published time.Time, exec time.Duration) BatchStat {
+	s := BatchStat{
+		Items:   items,
+		Callers: m.callers,
+		Exec:    exec,
+	}
+	if m.callers > 0 {
+		s.MaxWait = published.Sub(m.first)
+		s.MeanWait = (s.MaxWait*time.Duration(m.callers) - m.offsetSum) / time.Duration(m.callers)
+	}
+	return s
+}
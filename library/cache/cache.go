@@ -2,48 +2,274 @@ package cache
 
 import (
 	"sync"
+	"time"
 )
 
 type Cache[K comparable, V any] struct {
 	m        sync.RWMutex
-	valueMap map[K]V
+	valueMap map[K]entry[V]
+
+	ttl      time.Duration
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// maxSizeが0なら上限なし
+	maxSize int
+	// GetはRLockの中でAccessするのでpmで守る
+	pm       sync.Mutex
+	policy   Policy[K]
+	admitter Admitter[K]
+	onEvict  func(k K, v V)
+}
+
+type entry[V any] struct {
+	v V
+	// UnixNano、0なら期限なし
+	expire int64
+}
+
+func (e entry[V]) expired(now int64) bool {
+	return e.expire != 0 && now > e.expire
+}
+
+type options[K comparable, V any] struct {
+	ttl       time.Duration
+	janitor   time.Duration
+	maxSize   int
+	newPolicy func() Policy[K]
+	// WithMaxSizeで渡したpolicyはshardごとに分けられない
+	sharedPolicy bool
+	onEvict      func(k K, v V)
+}
+
+type Option[K comparable, V any] func(*options[K, V])
+
+// SetWithTTL以外で入れたものの期限
+// 0なら期限なし
+func WithTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.ttl = ttl
+	}
+}
+
+// intervalごとに期限切れのものを消す
+// 止めるにはCloseを呼ぶ
+func WithJanitor[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.janitor = interval
+	}
+}
+
+// 入れられる数の上限
+// 上限に達したらpolicyで選んだものを消す。nilならLRU
+func WithMaxSize[K comparable, V any](n int, policy Policy[K]) Option[K, V] {
+	if policy == nil {
+		return WithMaxSizeFunc[K, V](n, func() Policy[K] {
+			return NewLRU[K]()
+		})
+	}
+	return func(o *options[K, V]) {
+		o.maxSize = n
+		o.newPolicy = func() Policy[K] {
+			return policy
+		}
+		o.sharedPolicy = true
+	}
+}
+
+// WithMaxSizeと同じだが、policyをnewPolicyで作る
+// NewShardedではshardごとに作る
+func WithMaxSizeFunc[K comparable, V any](n int, newPolicy func() Policy[K]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.maxSize = n
+		o.newPolicy = newPolicy
+		o.sharedPolicy = false
+	}
+}
+
+// 上限に達したか期限切れで消えたときに呼ばれる
+// Cacheのロックを外してから呼ぶ
+func WithOnEvict[K comparable, V any](fn func(k K, v V)) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.onEvict = fn
+	}
+}
+
+func New[K comparable, V any](cap int, opts ...Option[K, V]) *Cache[K, V] {
+	return newCache(cap, newOptions(opts))
+}
+
+func newOptions[K comparable, V any](opts []Option[K, V]) options[K, V] {
+	o := options[K, V]{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func newCache[K comparable, V any](cap int, o options[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		valueMap: make(map[K]entry[V], cap),
+		ttl:      o.ttl,
+		onEvict:  o.onEvict,
+		stopCh:   make(chan struct{}),
+	}
+	if o.maxSize > 0 {
+		if o.newPolicy == nil {
+			panic("cache: policy is nil")
+		}
+		policy := o.newPolicy()
+		c.maxSize = o.maxSize
+		c.policy = policy
+		c.admitter, _ = policy.(Admitter[K])
+	}
+	if o.janitor > 0 {
+		go c.janitor(o.janitor)
+	}
+	return c
+}
+
+func (c *Cache[K, V]) newEntry(v V, ttl time.Duration) entry[V] {
+	e := entry[V]{v: v}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl).UnixNano()
+	}
+	return e
+}
+
+// 期限切れのものは無いものとして扱う
+func (c *Cache[K, V]) get(k K) (e entry[V], ok bool) {
+	e, ok = c.valueMap[k]
+	if !ok {
+		return e, false
+	}
+	if e.expire != 0 && e.expired(time.Now().UnixNano()) {
+		return entry[V]{}, false
+	}
+	return e, true
+}
+
+type evicted[K comparable, V any] struct {
+	k K
+	v V
+}
+
+// ロック中に呼ぶ
+// 上限に達していればpolicyで選んだものを消して、消したものを返す
+func (c *Cache[K, V]) put(k K, e entry[V]) (evs []evicted[K, V]) {
+	if c.policy == nil {
+		c.valueMap[k] = e
+		return nil
+	}
+	if _, ok := c.valueMap[k]; ok {
+		c.valueMap[k] = e
+		c.policy.Access(k)
+		return nil
+	}
+	for len(c.valueMap) >= c.maxSize {
+		victim, ok := c.policy.Victim()
+		if !ok {
+			break
+		}
+		if c.admitter != nil && !c.admitter.Admit(k, victim) {
+			return evs
+		}
+		evs = append(evs, evicted[K, V]{k: victim, v: c.valueMap[victim].v})
+		c.remove(victim)
+	}
+	c.valueMap[k] = e
+	c.policy.Add(k)
+	return evs
 }
 
-func New[K comparable, V any](cap int) *Cache[K, V] {
-	return &Cache[K, V]{
-		valueMap: make(map[K]V, cap),
+// ロック中に呼ぶ
+func (c *Cache[K, V]) remove(k K) {
+	delete(c.valueMap, k)
+	if c.policy != nil {
+		c.policy.Remove(k)
+	}
+}
+
+// ロックを外してから呼ぶ
+func (c *Cache[K, V]) evict(evs []evicted[K, V]) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, ev := range evs {
+		c.onEvict(ev.k, ev.v)
 	}
 }
 
 func (c *Cache[K, V]) Set(k K, v V) {
+	c.SetWithTTL(k, v, c.ttl)
+}
+
+// ttlが0なら期限なし
+func (c *Cache[K, V]) SetWithTTL(k K, v V, ttl time.Duration) {
+	e := c.newEntry(v, ttl)
 	c.m.Lock()
-	c.valueMap[k] = v
+	evs := c.put(k, e)
 	c.m.Unlock()
+	c.evict(evs)
 }
 
+// 期限は変わらない
 func (c *Cache[K, V]) Update(k K, fn func(current V) V) {
 	c.m.Lock()
-	current, ok := c.valueMap[k]
+	current, ok := c.get(k)
 	if !ok {
 		c.m.Unlock()
 		return
 	}
-	c.valueMap[k] = fn(current)
+	current.v = fn(current.v)
+	c.put(k, current)
 	c.m.Unlock()
 }
 
+// 既にある場合は期限は変わらない
 func (c *Cache[K, V]) UpdateOrSet(k K, fn func(current V, exist bool) V) {
 	c.m.Lock()
-	current, ok := c.valueMap[k]
-	c.valueMap[k] = fn(current, ok)
+	current, ok := c.get(k)
+	if ok {
+		current.v = fn(current.v, true)
+	} else {
+		current = c.newEntry(fn(current.v, false), c.ttl)
+	}
+	evs := c.put(k, current)
 	c.m.Unlock()
+	c.evict(evs)
 }
 
 func (c *Cache[K, V]) Get(k K) (V, bool) {
 	c.m.RLock()
-	v, ok := c.valueMap[k]
+	e, ok := c.valueMap[k]
+	if ok && c.policy != nil {
+		c.pm.Lock()
+		c.policy.Access(k)
+		c.pm.Unlock()
+	}
 	c.m.RUnlock()
-	return v, ok
+	if !ok {
+		return e.v, false
+	}
+	if e.expire != 0 && e.expired(time.Now().UnixNano()) {
+		c.deleteExpired(k)
+		var v V
+		return v, false
+	}
+	return e.v, true
+}
+
+// 他で入れ直されている場合は消さない
+func (c *Cache[K, V]) deleteExpired(k K) {
+	var evs []evicted[K, V]
+	c.m.Lock()
+	if e, ok := c.valueMap[k]; ok && e.expired(time.Now().UnixNano()) {
+		evs = append(evs, evicted[K, V]{k: k, v: e.v})
+		c.remove(k)
+	}
+	c.m.Unlock()
+	c.evict(evs)
 }
 
 func (c *Cache[K, V]) GetOrSet(k K, fn func() (V, error)) (res V, err error) {
@@ -62,31 +288,80 @@ func (c *Cache[K, V]) GetOrSet(k K, fn func() (V, error)) (res V, err error) {
 // fn実行中はcをロックする
 func (c *Cache[K, V]) GetOrSetLock(k K, fn func() (V, error)) (res V, err error) {
 	c.m.RLock()
-	v, ok := c.valueMap[k]
+	e, ok := c.get(k)
 	c.m.RUnlock()
 	if ok {
-		return v, nil
+		return e.v, nil
 	}
 	c.m.Lock()
-	v, ok = c.valueMap[k]
+	e, ok = c.get(k)
 	if ok {
 		c.m.Unlock()
-		return v, nil
+		return e.v, nil
 	}
-	v, err = fn()
+	v, err := fn()
 	if err != nil {
 		c.m.Unlock()
 		return res, err
 	}
-	c.valueMap[k] = v
+	evs := c.put(k, c.newEntry(v, c.ttl))
 	c.m.Unlock()
+	c.evict(evs)
 	return v, nil
 }
 
 func (c *Cache[K, V]) Delete(k K) (v V) {
 	c.m.Lock()
-	v = c.valueMap[k]
-	delete(c.valueMap, k)
+	e, _ := c.get(k)
+	c.remove(k)
+	c.m.Unlock()
+	return e.v
+}
+
+func (c *Cache[K, V]) DeleteAll() {
+	c.m.Lock()
+	if c.policy != nil {
+		for k := range c.valueMap {
+			c.policy.Remove(k)
+		}
+	}
+	c.valueMap = make(map[K]entry[V], len(c.valueMap))
+	c.m.Unlock()
+}
+
+// 期限切れのものを全て消す
+func (c *Cache[K, V]) DeleteExpired() {
+	var evs []evicted[K, V]
+	now := time.Now().UnixNano()
+	c.m.Lock()
+	for k, e := range c.valueMap {
+		if e.expired(now) {
+			if c.onEvict != nil {
+				evs = append(evs, evicted[K, V]{k: k, v: e.v})
+			}
+			c.remove(k)
+		}
+	}
 	c.m.Unlock()
-	return v
+	c.evict(evs)
+}
+
+func (c *Cache[K, V]) janitor(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.DeleteExpired()
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// WithJanitorのgoroutineを止める
+func (c *Cache[K, V]) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 type User struct {
@@ -134,3 +135,232 @@ func Test_Cache(t *testing.T) {
 		t.Errorf("key %v err want %v, got %v", "11", fmt.Errorf("something"), err)
 	}
 }
+
+func Test_Cache_ttl(t *testing.T) {
+	c := New[int, User](10, WithTTL[int, User](time.Millisecond*50))
+
+	c.SetWithTTL(1, newUser(1, 1), time.Millisecond*20)
+	c.SetWithTTL(2, newUser(2, 2), 0)
+	c.Set(3, newUser(3, 3))
+	c.UpdateOrSet(4, func(current User, exist bool) User {
+		return newUser(4, 4)
+	})
+	for key := 1; key <= 4; key++ {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("key %v ok want %v, got %v", key, true, ok)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if _, ok := c.Get(1); ok {
+		t.Errorf("key %v ok want %v, got %v", 1, false, ok)
+	}
+	c.Update(3, func(current User) User {
+		current.Name += "update"
+		return current
+	})
+
+	time.Sleep(time.Millisecond * 30)
+	for key, want := range map[int]bool{1: false, 2: true, 3: false, 4: false} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("key %v ok want %v, got %v", key, want, ok)
+		}
+	}
+
+	// 期限切れならfnが呼ばれる
+	called := 0
+	fn := func() (User, error) {
+		called++
+		return newUser(3, called), nil
+	}
+	c.GetOrSet(3, fn)
+	c.GetOrSetLock(3, fn)
+	if called != 1 {
+		t.Errorf("called want %v, got %v", 1, called)
+	}
+}
+
+func Test_Cache_janitor(t *testing.T) {
+	c := New[int, User](10, WithTTL[int, User](time.Millisecond*10), WithJanitor[int, User](time.Millisecond*10))
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		c.Set(i, newUser(i, i))
+	}
+	c.SetWithTTL(10, newUser(10, 10), 0)
+
+	time.Sleep(time.Millisecond * 50)
+	c.m.RLock()
+	n := len(c.valueMap)
+	c.m.RUnlock()
+	if n != 1 {
+		t.Errorf("len want %v, got %v", 1, n)
+	}
+}
+
+func Test_Cache_maxSize(t *testing.T) {
+	evicted := []int{}
+	onEvict := WithOnEvict(func(k int, v User) {
+		if v.ID != k {
+			t.Errorf("v.ID want %v, got %v", k, v.ID)
+		}
+		evicted = append(evicted, k)
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		evicted = evicted[:0]
+		c := New[int, User](3, WithMaxSize[int, User](3, nil), onEvict)
+		for i := 0; i < 3; i++ {
+			c.Set(i, newUser(i, i))
+		}
+		c.Get(0)
+		c.Set(3, newUser(3, 3))
+		c.Set(4, newUser(4, 4))
+		if fmt.Sprint(evicted) != "[1 2]" {
+			t.Errorf("evicted want %v, got %v", "[1 2]", evicted)
+		}
+		for key, want := range map[int]bool{0: true, 1: false, 2: false, 3: true, 4: true} {
+			if _, ok := c.Get(key); ok != want {
+				t.Errorf("key %v ok want %v, got %v", key, want, ok)
+			}
+		}
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		evicted = evicted[:0]
+		c := New[int, User](3, WithMaxSize[int, User](3, NewLFU[int]()), onEvict)
+		for i := 0; i < 3; i++ {
+			c.Set(i, newUser(i, i))
+		}
+		c.Get(0)
+		c.Get(0)
+		c.Get(1)
+		c.Get(2)
+		c.Set(3, newUser(3, 3))
+		c.Set(4, newUser(4, 4))
+		if fmt.Sprint(evicted) != "[1 3]" {
+			t.Errorf("evicted want %v, got %v", "[1 3]", evicted)
+		}
+	})
+
+	t.Run("tinylfu", func(t *testing.T) {
+		evicted = evicted[:0]
+		c := New[int, User](10, WithMaxSize[int, User](10, NewTinyLFU(10, func(k int) uint64 {
+			return uint64(k) * 0x9e3779b97f4a7c15
+		})), onEvict)
+		for i := 0; i < 10; i++ {
+			c.Set(i, newUser(i, i))
+			for j := 0; j < 5; j++ {
+				c.Get(i)
+			}
+		}
+		// 1回しか使われないものでは追い出されない
+		for i := 100; i < 200; i++ {
+			c.Set(i, newUser(i, i))
+		}
+		if len(evicted) != 0 {
+			t.Errorf("len(evicted) want %v, got %v", 0, len(evicted))
+		}
+		for i := 0; i < 10; i++ {
+			if _, ok := c.Get(i); !ok {
+				t.Errorf("key %v ok want %v, got %v", i, true, ok)
+			}
+		}
+	})
+}
+
+func Test_Cache_maxSize_go(t *testing.T) {
+	c := New[int, User](10, WithMaxSize[int, User](10, NewLFU[int]()), WithTTL[int, User](time.Millisecond))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := i % 20
+			switch i % 3 {
+			case 0:
+				c.Set(key, newUser(key, i))
+			case 1:
+				c.Get(key)
+			case 2:
+				c.UpdateOrSet(key, func(current User, exist bool) User {
+					return newUser(key, i)
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.m.RLock()
+	defer c.m.RUnlock()
+	if len(c.valueMap) > 10 {
+		t.Errorf("len want <= %v, got %v", 10, len(c.valueMap))
+	}
+}
+
+func Test_Sharded(t *testing.T) {
+	hasher := func(k int) uint64 {
+		return uint64(k)
+	}
+	c := NewSharded[int, User](4, hasher)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := i % 16
+			switch i / 16 % 4 {
+			case 0:
+				c.Set(key, newUser(key, i))
+			case 1:
+				c.Get(key)
+			case 2:
+				c.Update(key, func(current User) User {
+					current.Name += "update"
+					return current
+				})
+			case 3:
+				c.UpdateOrSet(key, func(current User, exist bool) User {
+					return newUser(key, i)
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	for key := 0; key < 16; key++ {
+		u, ok := c.Get(key)
+		if !ok {
+			t.Fatalf("key %v ok want %v, got %v", key, true, ok)
+		}
+		if u.ID != key {
+			t.Errorf("key %v u.ID want %v, got %v", key, key, u.ID)
+		}
+		if len(c.shards[key%4].valueMap) != 4 {
+			t.Errorf("key %v shard len want %v, got %v", key, 4, len(c.shards[key%4].valueMap))
+		}
+	}
+
+	v, err := c.GetOrSetLock(100, func() (User, error) {
+		return newUser(100, 100), nil
+	})
+	if err != nil || v.ID != 100 {
+		t.Errorf("key %v want %v, got %v, %v", 100, newUser(100, 100), v, err)
+	}
+	if u := c.Delete(100); u.ID != 100 {
+		t.Errorf("key %v u.ID want %v, got %v", 100, 100, u.ID)
+	}
+	if _, ok := c.Get(100); ok {
+		t.Errorf("key %v ok want %v, got %v", 100, false, ok)
+	}
+
+	// 上限はshardごとに分ける
+	c = NewSharded[int, User](4, hasher, WithMaxSizeFunc[int, User](8, func() Policy[int] {
+		return NewLFU[int]()
+	}))
+	for i := 0; i < 100; i++ {
+		c.Set(i, newUser(i, i))
+	}
+	for i, s := range c.shards {
+		if len(s.valueMap) != 2 {
+			t.Errorf("shard %v len want %v, got %v", i, 2, len(s.valueMap))
+		}
+	}
+}
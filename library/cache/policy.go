@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// 上限に達したときに消すキーを選ぶ
+// Cacheのロック中に呼ばれるので、1つのCacheでのみ使う
+type Policy[K comparable] interface {
+	// 新しく入った
+	Add(k K)
+	// GetやSetで使われた
+	Access(k K)
+	// 消された
+	Remove(k K)
+	// 次に消すキー
+	Victim() (k K, ok bool)
+}
+
+// Policyが実装していれば、上限に達したときにkを入れるためにvictimを消してよいか聞く
+// falseならkは入れない
+type Admitter[K comparable] interface {
+	Admit(k K, victim K) bool
+}
+
+// 最後に使われたのが最も古いものを消す
+type LRU[K comparable] struct {
+	l *list.List
+	m map[K]*list.Element
+}
+
+func NewLRU[K comparable]() *LRU[K] {
+	return &LRU[K]{
+		l: list.New(),
+		m: map[K]*list.Element{},
+	}
+}
+
+func (p *LRU[K]) Add(k K) {
+	if e, ok := p.m[k]; ok {
+		p.l.MoveToFront(e)
+		return
+	}
+	p.m[k] = p.l.PushFront(k)
+}
+
+func (p *LRU[K]) Access(k K) {
+	if e, ok := p.m[k]; ok {
+		p.l.MoveToFront(e)
+	}
+}
+
+func (p *LRU[K]) Remove(k K) {
+	if e, ok := p.m[k]; ok {
+		p.l.Remove(e)
+		delete(p.m, k)
+	}
+}
+
+func (p *LRU[K]) Victim() (k K, ok bool) {
+	e := p.l.Back()
+	if e == nil {
+		return k, false
+	}
+	return e.Value.(K), true
+}
+
+// 使われた回数が最も少ないものを消す
+// 同じ回数なら最後に使われたのが古いものを消す
+type LFU[K comparable] struct {
+	h    lfuHeap[K]
+	m    map[K]*lfuItem[K]
+	tick uint64
+}
+
+type lfuItem[K comparable] struct {
+	k     K
+	count uint64
+	tick  uint64
+	index int
+}
+
+type lfuHeap[K comparable] []*lfuItem[K]
+
+func (h lfuHeap[K]) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x any) {
+	item := x.(*lfuItem[K])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap[K]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+func NewLFU[K comparable]() *LFU[K] {
+	return &LFU[K]{
+		m: map[K]*lfuItem[K]{},
+	}
+}
+
+func (p *LFU[K]) Add(k K) {
+	if _, ok := p.m[k]; ok {
+		p.Access(k)
+		return
+	}
+	p.tick++
+	item := &lfuItem[K]{
+		k:     k,
+		count: 1,
+		tick:  p.tick,
+	}
+	p.m[k] = item
+	heap.Push(&p.h, item)
+}
+
+func (p *LFU[K]) Access(k K) {
+	item, ok := p.m[k]
+	if !ok {
+		return
+	}
+	p.tick++
+	item.count++
+	item.tick = p.tick
+	heap.Fix(&p.h, item.index)
+}
+
+func (p *LFU[K]) Remove(k K) {
+	item, ok := p.m[k]
+	if !ok {
+		return
+	}
+	heap.Remove(&p.h, item.index)
+	delete(p.m, k)
+}
+
+func (p *LFU[K]) Victim() (k K, ok bool) {
+	if len(p.h) == 0 {
+		return k, false
+	}
+	return p.h[0].k, true
+}
+
+// LRUで消すものを選び、最近の使われた回数がそれより多いものだけを入れる
+// 回数はcount-min sketchで数え、size*10回数えるごとに半分にする
+type TinyLFU[K comparable] struct {
+	*LRU[K]
+	hash    func(K) uint64
+	rows    [4][]uint8
+	mask    uint64
+	added   int
+	resetAt int
+}
+
+// sizeはWithMaxSizeに渡す上限と同じくらいにする
+func NewTinyLFU[K comparable](size int, hash func(K) uint64) *TinyLFU[K] {
+	// 衝突で1回しか使われていないものが多く見積もられないように上限より広くする
+	width := 16
+	for width < size*8 {
+		width <<= 1
+	}
+	p := &TinyLFU[K]{
+		LRU:     NewLRU[K](),
+		hash:    hash,
+		mask:    uint64(width - 1),
+		resetAt: size * 10,
+	}
+	for i := range p.rows {
+		p.rows[i] = make([]uint8, width)
+	}
+	if p.resetAt < width {
+		p.resetAt = width
+	}
+	return p
+}
+
+func (p *TinyLFU[K]) Access(k K) {
+	p.increment(k)
+	p.LRU.Access(k)
+}
+
+func (p *TinyLFU[K]) Admit(k K, victim K) bool {
+	p.increment(k)
+	return p.estimate(k) > p.estimate(victim)
+}
+
+func (p *TinyLFU[K]) index(h uint64, i int) uint64 {
+	h1, h2 := h&0xffffffff, h>>32|1
+	return (h1 + uint64(i)*h2) & p.mask
+}
+
+func (p *TinyLFU[K]) increment(k K) {
+	h := p.hash(k)
+	for i, row := range p.rows {
+		idx := p.index(h, i)
+		if row[idx] < 15 {
+			row[idx]++
+		}
+	}
+	p.added++
+	if p.added >= p.resetAt {
+		for _, row := range p.rows {
+			for i := range row {
+				row[i] >>= 1
+			}
+		}
+		p.added /= 2
+	}
+}
+
+func (p *TinyLFU[K]) estimate(k K) uint8 {
+	h := p.hash(k)
+	min := uint8(255)
+	for i, row := range p.rows {
+		if c := row[p.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
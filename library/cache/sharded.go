@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"time"
+)
+
+// キーをhasherでshardに振り分け、shardごとにロックする
+type Sharded[K comparable, V any] struct {
+	shards []*Cache[K, V]
+	hasher func(K) uint64
+}
+
+// WithMaxSizeの上限はshardごとに均等に分ける
+// policyはshardごとに必要なので、WithMaxSizeでnil以外を渡す場合はWithMaxSizeFuncを使う
+func NewSharded[K comparable, V any](shards int, hasher func(K) uint64, opts ...Option[K, V]) *Sharded[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	o := newOptions(opts)
+	if o.maxSize > 0 {
+		if o.sharedPolicy {
+			panic("cache: policy can not be shared between shards, use WithMaxSizeFunc")
+		}
+		o.maxSize = (o.maxSize + shards - 1) / shards
+	}
+	s := &Sharded[K, V]{
+		shards: make([]*Cache[K, V], shards),
+		hasher: hasher,
+	}
+	for i := range s.shards {
+		s.shards[i] = newCache(0, o)
+	}
+	return s
+}
+
+func (s *Sharded[K, V]) shard(k K) *Cache[K, V] {
+	return s.shards[s.hasher(k)%uint64(len(s.shards))]
+}
+
+func (s *Sharded[K, V]) Set(k K, v V) {
+	s.shard(k).Set(k, v)
+}
+
+func (s *Sharded[K, V]) SetWithTTL(k K, v V, ttl time.Duration) {
+	s.shard(k).SetWithTTL(k, v, ttl)
+}
+
+func (s *Sharded[K, V]) Update(k K, fn func(current V) V) {
+	s.shard(k).Update(k, fn)
+}
+
+func (s *Sharded[K, V]) UpdateOrSet(k K, fn func(current V, exist bool) V) {
+	s.shard(k).UpdateOrSet(k, fn)
+}
+
+func (s *Sharded[K, V]) Get(k K) (V, bool) {
+	return s.shard(k).Get(k)
+}
+
+func (s *Sharded[K, V]) GetOrSet(k K, fn func() (V, error)) (V, error) {
+	return s.shard(k).GetOrSet(k, fn)
+}
+
+// fn実行中はkのshardをロックする
+func (s *Sharded[K, V]) GetOrSetLock(k K, fn func() (V, error)) (V, error) {
+	return s.shard(k).GetOrSetLock(k, fn)
+}
+
+func (s *Sharded[K, V]) Delete(k K) V {
+	return s.shard(k).Delete(k)
+}
+
+func (s *Sharded[K, V]) DeleteAll() {
+	for _, c := range s.shards {
+		c.DeleteAll()
+	}
+}
+
+func (s *Sharded[K, V]) DeleteExpired() {
+	for _, c := range s.shards {
+		c.DeleteExpired()
+	}
+}
+
+func (s *Sharded[K, V]) Close() {
+	for _, c := range s.shards {
+		c.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package dataloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// リクエストごとに新しいDataLoaderを作る
+type Registry struct {
+	m         sync.RWMutex
+	factories map[string]func() any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]func() any, 10),
+	}
+}
+
+// メソッドは型パラメータを持てないので関数にしている
+func Register[K comparable, V any](r *Registry, name string, newFn func() *DataLoader[K, V]) {
+	r.m.Lock()
+	r.factories[name] = func() any {
+		return newFn()
+	}
+	r.m.Unlock()
+}
+
+type ctxKey struct{ string }
+
+var scopeKey = &ctxKey{"scope"}
+
+// DataLoaderは最初にFromContextで取り出した時に作る
+type scope struct {
+	r       *Registry
+	m       sync.Mutex
+	loaders map[string]any
+}
+
+func (r *Registry) WithContext(ctx context.Context) context.Context {
+	r.m.RLock()
+	n := len(r.factories)
+	r.m.RUnlock()
+	return context.WithValue(ctx, scopeKey, &scope{
+		r:       r,
+		loaders: make(map[string]any, n),
+	})
+}
+
+// WithContextで付けたDataLoaderを全てCloseする
+func CloseContext(ctx context.Context) {
+	s, ok := ctx.Value(scopeKey).(*scope)
+	if !ok {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, l := range s.loaders {
+		if c, ok := l.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+// net/http, chi, gojiのmiddleware
+// リクエストが終わったらDataLoaderをCloseする
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := r.WithContext(req.Context())
+		defer CloseContext(ctx)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+func (r *Registry) EchoMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := r.WithContext(c.Request().Context())
+			defer CloseContext(ctx)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// ctxにRegistryが付いていないかnameが登録されていなければnilを返す
+func FromContext[K comparable, V any](ctx context.Context, name string) *DataLoader[K, V] {
+	s, ok := ctx.Value(scopeKey).(*scope)
+	if !ok {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	l, ok := s.loaders[name]
+	if !ok {
+		s.r.m.RLock()
+		newFn, ok := s.r.factories[name]
+		s.r.m.RUnlock()
+		if !ok {
+			return nil
+		}
+		l = newFn()
+		s.loaders[name] = l
+	}
+	dl, ok := l.(*DataLoader[K, V])
+	if !ok {
+		panic(fmt.Sprintf("dataloader: %v is %T, not %T", name, l, dl))
+	}
+	return dl
+}
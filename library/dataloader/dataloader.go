@@ -1,30 +1,272 @@
 package dataloader
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/yutakahashi114/isutool/library/bulkexecute"
+	"github.com/yutakahashi114/isutool/library/cache"
 )
 
+var ErrNotFound = errors.New("not found")
+
 type DataLoader[K comparable, V any] struct {
 	be *bulkexecute.BulkExecute[K, map[K]V]
+
+	// 見つかったもの、WithCacheの時だけ
+	cache *cache.Cache[K, V]
+	// 見つからなかったもの、WithNegativeCacheの時だけ
+	negative *cache.Cache[K, struct{}]
+
+	missingAsZero bool
+
+	exec    func([]K) (map[K]V, error)
+	maxKeys int
+}
+
+// キャッシュから取り出したもの
+type cacheEntry[V any] struct {
+	v        V
+	notFound bool
+}
+
+type options[K comparable, V any] struct {
+	cache         []cache.Option[K, V]
+	negative      []cache.Option[K, struct{}]
+	missingAsZero bool
+	maxKeys       int
+}
+
+type Option[K comparable, V any] func(*options[K, V])
+
+// Loadした結果をキャッシュする
+// ttlが0なら期限なし
+// optsでcache.WithMaxSizeなどを渡せる
+func WithCache[K comparable, V any](ttl time.Duration, opts ...cache.Option[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.cache = append([]cache.Option[K, V]{cache.WithTTL[K, V](ttl)}, opts...)
+	}
+}
+
+// 見つからなかったキーをキャッシュし、期限まではexecせずにErrNotFoundを返す
+// ttlが0なら期限なし
+func WithNegativeCache[K comparable, V any](ttl time.Duration, opts ...cache.Option[K, struct{}]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.negative = append([]cache.Option[K, struct{}]{cache.WithTTL[K, struct{}](ttl)}, opts...)
+	}
+}
+
+// 見つからなかったキーはErrNotFoundではなくゼロ値を返す
+func WithMissingAsZero[K comparable, V any]() Option[K, V] {
+	return func(o *options[K, V]) {
+		o.missingAsZero = true
+	}
 }
 
-func New[K comparable, V any](exec func([]K) (map[K]V, error), wait time.Duration) *DataLoader[K, V] {
-	return &DataLoader[K, V]{
-		be: bulkexecute.New(exec, wait, 64),
+// 1回のexecに渡すキーの数の上限
+// 超えた分は分割して並列にexecする
+func WithMaxKeys[K comparable, V any](n int) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.maxKeys = n
 	}
 }
 
+func New[K comparable, V any](exec func([]K) (map[K]V, error), wait time.Duration, opts ...Option[K, V]) *DataLoader[K, V] {
+	o := options[K, V]{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dl := &DataLoader[K, V]{
+		missingAsZero: o.missingAsZero,
+		exec:          exec,
+		maxKeys:       o.maxKeys,
+	}
+	dl.be = bulkexecute.New(dl.execute, wait, 64)
+	if o.cache != nil {
+		dl.cache = cache.New(64, o.cache...)
+	}
+	if o.negative != nil {
+		dl.negative = cache.New(64, o.negative...)
+	}
+	return dl
+}
+
+// 同じキーは1つにまとめてからexecする
+func (dl *DataLoader[K, V]) execute(keys []K) (map[K]V, error) {
+	seen := make(map[K]struct{}, len(keys))
+	uniq := make([]K, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		uniq = append(uniq, key)
+	}
+	if dl.maxKeys <= 0 || len(uniq) <= dl.maxKeys {
+		return dl.exec(uniq)
+	}
+
+	m := sync.Mutex{}
+	res := make(map[K]V, len(uniq))
+	var resErr error
+	wg := sync.WaitGroup{}
+	for start := 0; start < len(uniq); start += dl.maxKeys {
+		end := start + dl.maxKeys
+		if end > len(uniq) {
+			end = len(uniq)
+		}
+		wg.Add(1)
+		go func(chunk []K) {
+			defer wg.Done()
+			r, err := dl.exec(chunk)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				if resErr == nil {
+					resErr = err
+				}
+				return
+			}
+			for k, v := range r {
+				res[k] = v
+			}
+		}(uniq[start:end:end])
+	}
+	wg.Wait()
+	if resErr != nil {
+		return nil, resErr
+	}
+	return res, nil
+}
+
 func (dl *DataLoader[K, V]) Load(key K) (v V, err error) {
-	m, err := dl.be.Execute(key)
+	return dl.LoadContext(context.Background(), key)
+}
+
+// ctxが終わったら結果を待たずにctx.Err()を返す
+func (dl *DataLoader[K, V]) LoadContext(ctx context.Context, key K) (v V, err error) {
+	if e, ok := dl.getCache(key); ok {
+		return dl.result(e)
+	}
+	m, err := dl.be.ExecuteContext(ctx, key)
 	if err != nil {
 		return v, err
 	}
 	v, ok := m[key]
 	if !ok {
-		return v, errors.New("not found")
+		dl.primeNotFound(key)
+		return dl.result(cacheEntry[V]{notFound: true})
 	}
+	dl.Prime(key, v)
 	return v, nil
 }
+
+func (dl *DataLoader[K, V]) result(e cacheEntry[V]) (v V, err error) {
+	if e.notFound && !dl.missingAsZero {
+		return v, ErrNotFound
+	}
+	return e.v, nil
+}
+
+// keysをまとめて1回で取得する
+// 見つからなかったキーは返り値に含まれない
+// WithMissingAsZeroの場合はゼロ値が入る
+func (dl *DataLoader[K, V]) LoadMany(keys []K) (map[K]V, error) {
+	return dl.LoadManyContext(context.Background(), keys)
+}
+
+func (dl *DataLoader[K, V]) LoadManyContext(ctx context.Context, keys []K) (map[K]V, error) {
+	res := make(map[K]V, len(keys))
+	missed := make([]K, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := dl.getCache(key); ok {
+			if v, err := dl.result(e); err == nil {
+				res[key] = v
+			}
+			continue
+		}
+		missed = append(missed, key)
+	}
+	if len(missed) == 0 {
+		return res, nil
+	}
+	m, err := dl.be.ExecuteContext(ctx, missed...)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range missed {
+		v, ok := m[key]
+		if !ok {
+			dl.primeNotFound(key)
+			if dl.missingAsZero {
+				res[key] = v
+			}
+			continue
+		}
+		res[key] = v
+		dl.Prime(key, v)
+	}
+	return res, nil
+}
+
+func (dl *DataLoader[K, V]) getCache(key K) (e cacheEntry[V], ok bool) {
+	if dl.cache != nil {
+		if e.v, ok = dl.cache.Get(key); ok {
+			return e, true
+		}
+	}
+	if dl.negative != nil {
+		if _, ok = dl.negative.Get(key); ok {
+			return cacheEntry[V]{notFound: true}, true
+		}
+	}
+	return e, false
+}
+
+// キャッシュに入れる
+// 既にある場合は上書きする
+// 見つからなかったというキャッシュは消す
+func (dl *DataLoader[K, V]) Prime(key K, v V) {
+	if dl.negative != nil {
+		dl.negative.Delete(key)
+	}
+	if dl.cache != nil {
+		dl.cache.Set(key, v)
+	}
+}
+
+func (dl *DataLoader[K, V]) primeNotFound(key K) {
+	if dl.negative != nil {
+		dl.negative.Set(key, struct{}{})
+	}
+}
+
+func (dl *DataLoader[K, V]) Clear(key K) {
+	if dl.cache != nil {
+		dl.cache.Delete(key)
+	}
+	if dl.negative != nil {
+		dl.negative.Delete(key)
+	}
+}
+
+func (dl *DataLoader[K, V]) ClearAll() {
+	if dl.cache != nil {
+		dl.cache.DeleteAll()
+	}
+	if dl.negative != nil {
+		dl.negative.DeleteAll()
+	}
+}
+
+// cache.WithJanitorを渡した場合はCloseで止める
+func (dl *DataLoader[K, V]) Close() {
+	if dl.cache != nil {
+		dl.cache.Close()
+	}
+	if dl.negative != nil {
+		dl.negative.Close()
+	}
+}
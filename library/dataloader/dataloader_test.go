@@ -0,0 +1,347 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/yutakahashi114/isutool/library/cache"
+)
+
+type User struct {
+	ID   int
+	Name string
+}
+
+func newUser(i int) User {
+	return User{
+		ID:   i,
+		Name: fmt.Sprintf("name:%v", i),
+	}
+}
+
+type store struct {
+	m     sync.Mutex
+	calls int
+	keys  [][]int
+}
+
+func (s *store) exec(ids []int) (map[int]User, error) {
+	s.m.Lock()
+	s.calls++
+	s.keys = append(s.keys, ids)
+	s.m.Unlock()
+	res := make(map[int]User, len(ids))
+	for _, id := range ids {
+		if id < 0 {
+			continue
+		}
+		res[id] = newUser(id)
+	}
+	return res, nil
+}
+
+func (s *store) getCalls() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.calls
+}
+
+func Test_DataLoader_cache(t *testing.T) {
+	s := &store{}
+	dl := New(s.exec, time.Millisecond, WithCache[int, User](time.Millisecond*50))
+
+	for i := 0; i < 3; i++ {
+		u, err := dl.Load(1)
+		if err != nil {
+			t.Errorf("err want %v, got %v", nil, err)
+		}
+		if u != newUser(1) {
+			t.Errorf("u want %v, got %v", newUser(1), u)
+		}
+	}
+	if s.getCalls() != 1 {
+		t.Errorf("calls want %v, got %v", 1, s.getCalls())
+	}
+
+	dl.Prime(2, User{ID: 2, Name: "primed"})
+	u, _ := dl.Load(2)
+	if u.Name != "primed" {
+		t.Errorf("u.Name want %v, got %v", "primed", u.Name)
+	}
+
+	dl.Clear(2)
+	u, _ = dl.Load(2)
+	if u != newUser(2) {
+		t.Errorf("u want %v, got %v", newUser(2), u)
+	}
+	if s.getCalls() != 2 {
+		t.Errorf("calls want %v, got %v", 2, s.getCalls())
+	}
+
+	dl.ClearAll()
+	dl.Load(1)
+	dl.Load(2)
+	if s.getCalls() != 4 {
+		t.Errorf("calls want %v, got %v", 4, s.getCalls())
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	dl.Load(1)
+	if s.getCalls() != 5 {
+		t.Errorf("calls want %v, got %v", 5, s.getCalls())
+	}
+}
+
+func Test_DataLoader_cache_maxSize(t *testing.T) {
+	s := &store{}
+	evicted := []int{}
+	dl := New(s.exec, time.Millisecond, WithCache(0,
+		cache.WithMaxSize[int, User](2, nil),
+		cache.WithOnEvict(func(k int, u User) {
+			evicted = append(evicted, k)
+		}),
+	))
+
+	for i := 0; i < 3; i++ {
+		dl.Load(i)
+	}
+	if fmt.Sprint(evicted) != "[0]" {
+		t.Errorf("evicted want %v, got %v", "[0]", evicted)
+	}
+	dl.Load(2)
+	dl.Load(0)
+	if s.getCalls() != 4 {
+		t.Errorf("calls want %v, got %v", 4, s.getCalls())
+	}
+}
+
+func Test_DataLoader_LoadMany(t *testing.T) {
+	s := &store{}
+	dl := New(s.exec, time.Millisecond*10)
+
+	keys := make([]int, 0, 51)
+	for i := 0; i < 50; i++ {
+		keys = append(keys, i)
+	}
+	keys = append(keys, -1)
+	res, err := dl.LoadMany(keys)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if len(res) != 50 {
+		t.Errorf("len(res) want %v, got %v", 50, len(res))
+	}
+	for i := 0; i < 50; i++ {
+		if res[i] != newUser(i) {
+			t.Errorf("res[%v] want %v, got %v", i, newUser(i), res[i])
+		}
+	}
+	if s.getCalls() != 1 {
+		t.Errorf("calls want %v, got %v", 1, s.getCalls())
+	}
+}
+
+func Test_DataLoader_LoadContext(t *testing.T) {
+	s := &store{}
+	dl := New(s.exec, time.Millisecond*50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := dl.LoadContext(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err want %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	u, err := dl.LoadContext(context.Background(), 2)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if u != newUser(2) {
+		t.Errorf("u want %v, got %v", newUser(2), u)
+	}
+}
+
+func Test_DataLoader_notFound(t *testing.T) {
+	s := &store{}
+	dl := New(s.exec, time.Millisecond, WithNegativeCache[int, User](0))
+
+	for i := 0; i < 3; i++ {
+		_, err := dl.Load(-1)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("err want %v, got %v", ErrNotFound, err)
+		}
+	}
+	if s.getCalls() != 1 {
+		t.Errorf("calls want %v, got %v", 1, s.getCalls())
+	}
+	// 見つかったものはキャッシュしない
+	dl.Load(1)
+	dl.Load(1)
+	if s.getCalls() != 3 {
+		t.Errorf("calls want %v, got %v", 3, s.getCalls())
+	}
+
+	// 追加してPrimeしたら見つかる
+	inserted := false
+	dl = New(func(ids []int) (map[int]User, error) {
+		if !inserted {
+			return map[int]User{}, nil
+		}
+		return s.exec(ids)
+	}, time.Millisecond, WithNegativeCache[int, User](0))
+	_, err := dl.Load(5)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("err want %v, got %v", ErrNotFound, err)
+	}
+	inserted = true
+	dl.Prime(5, newUser(5))
+	u, err := dl.Load(5)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if u != newUser(5) {
+		t.Errorf("u want %v, got %v", newUser(5), u)
+	}
+
+	dl = New(s.exec, time.Millisecond, WithMissingAsZero[int, User]())
+	u, err = dl.Load(-1)
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if u != (User{}) {
+		t.Errorf("u want %v, got %v", User{}, u)
+	}
+	res, err := dl.LoadMany([]int{1, -1})
+	if err != nil {
+		t.Errorf("err want %v, got %v", nil, err)
+	}
+	if len(res) != 2 || res[-1] != (User{}) {
+		t.Errorf("res want %v, got %v", map[int]User{1: newUser(1), -1: {}}, res)
+	}
+}
+
+func Test_Registry(t *testing.T) {
+	s := &store{}
+	r := NewRegistry()
+	Register(r, "user", func() *DataLoader[int, User] {
+		return New(s.exec, time.Millisecond, WithCache[int, User](0))
+	})
+
+	var first *DataLoader[int, User]
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		dl := FromContext[int, User](req.Context(), "user")
+		if dl == nil {
+			t.Fatalf("dl is nil")
+		}
+		if dl != FromContext[int, User](req.Context(), "user") {
+			t.Errorf("dl is not same in a request")
+		}
+		if dl == first {
+			t.Errorf("dl is shared across requests")
+		}
+		first = dl
+		dl.Load(1)
+		dl.Load(1)
+		if FromContext[int, User](req.Context(), "unknown") != nil {
+			t.Errorf("unknown dl is not nil")
+		}
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if s.getCalls() != 2 {
+		t.Errorf("calls want %v, got %v", 2, s.getCalls())
+	}
+
+	e := echo.New()
+	e.Use(r.EchoMiddleware())
+	e.GET("/", func(c echo.Context) error {
+		if FromContext[int, User](c.Request().Context(), "user") == nil {
+			t.Errorf("dl is nil")
+		}
+		return nil
+	})
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if FromContext[int, User](context.Background(), "user") != nil {
+		t.Errorf("dl without registry is not nil")
+	}
+}
+
+func Test_Registry_close(t *testing.T) {
+	s := &store{}
+	r := NewRegistry()
+	Register(r, "user", func() *DataLoader[int, User] {
+		return New(s.exec, time.Millisecond, WithCache(0, cache.WithJanitor[int, User](time.Millisecond)))
+	})
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext[int, User](req.Context(), "user").Load(1)
+	}))
+	e := echo.New()
+	e.Use(r.EchoMiddleware())
+	e.GET("/", func(c echo.Context) error {
+		_, err := FromContext[int, User](c.Request().Context(), "user").Load(1)
+		return err
+	})
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	// janitorのgoroutineが終わるのを待つ
+	time.Sleep(time.Millisecond * 20)
+	if after := runtime.NumGoroutine(); after-before >= 20 {
+		t.Errorf("goroutines want < %v, got %v", before+20, after)
+	}
+}
+
+func Test_DataLoader_dedupe(t *testing.T) {
+	s := &store{}
+	dl := New(s.exec, time.Millisecond*20, WithMaxKeys[int, User](3))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := i % 5
+			u, err := dl.Load(key)
+			if err != nil {
+				t.Errorf("err want %v, got %v", nil, err)
+			}
+			if u != newUser(key) {
+				t.Errorf("u want %v, got %v", newUser(key), u)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	seen := map[int]int{}
+	for _, keys := range s.keys {
+		if len(keys) > 3 {
+			t.Errorf("len(keys) want <= %v, got %v", 3, len(keys))
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("key %v is executed %v times", key, n)
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("len(seen) want %v, got %v", 5, len(seen))
+	}
+}
@@ -0,0 +1,30 @@
+package trace
+
+import (
+	"github.com/yutakahashi114/isutool/library/bulkexecute"
+)
+
+// bulkexecute.WithObserverに渡すとexecごとの状況を_trace.tsvに出す
+func (t *Tracer) BulkExecuteObserver(name string) bulkexecute.Observer {
+	return &bulkExecuteObserver{t: t, name: name}
+}
+
+type bulkExecuteObserver struct {
+	t    *Tracer
+	name string
+}
+
+func (o *bulkExecuteObserver) ObserveBatch(s bulkexecute.BatchStat) {
+	bms := []*baseMetric{
+		{path: o.name, query: "exec(ms)", duration: float64(s.Exec.Microseconds()) / 1000},
+		{path: o.name, query: "mean wait(ms)", duration: float64(s.MeanWait.Microseconds()) / 1000},
+		{path: o.name, query: "max wait(ms)", duration: float64(s.MaxWait.Microseconds()) / 1000},
+		{path: o.name, query: "items(count)", duration: float64(s.Items)},
+		{path: o.name, query: "callers(count)", duration: float64(s.Callers)},
+	}
+	go func() {
+		o.t.bmsMutex.Lock()
+		o.t.bms = append(o.t.bms, bms...)
+		o.t.bmsMutex.Unlock()
+	}()
+}
@@ -26,6 +26,9 @@ type Tracer struct {
 	hmsMutex sync.Mutex
 	hms      httpMetrics
 
+	bmsMutex sync.Mutex
+	bms      bulkExecuteMetrics
+
 	filePrefix string
 
 	*SQLLogger
@@ -36,6 +39,7 @@ func New(filePrefix string) *Tracer {
 		rms:        make(requestMetrics, 0, 100000),
 		sms:        make(sqlMetrics, 0, 2500000),
 		hms:        make(httpMetrics, 0, 100000),
+		bms:        make(bulkExecuteMetrics, 0, 100000),
 		filePrefix: filePrefix,
 	}
 	t.SQLLogger = newSQLLogger(t)
@@ -52,6 +56,7 @@ func (t *Tracer) StartTrace(duration time.Duration, db queryDB) {
 	t.rms = t.rms[:0]
 	t.sms = t.sms[:0]
 	t.hms = t.hms[:0]
+	t.bms = t.bms[:0]
 	t.bindedSQLLogger.queries = t.bindedSQLLogger.queries[:0]
 	t.bindedSQLLogger.sampled = make(map[string]struct{}, 30)
 	go func() {
@@ -146,6 +151,9 @@ type sqlMetrics baseMetrics
 
 type httpMetrics baseMetrics
 
+// pathにBulkExecuteの名前、queryに項目を入れる
+type bulkExecuteMetrics baseMetrics
+
 type requestStat struct {
 	path      string
 	count     int
@@ -285,6 +293,33 @@ func (t *Tracer) writeStats() error {
 			rs.query,
 		})
 	}
+
+	// msとcountが混ざるので単位はqueryの方に書く
+	w.Write([]string{"bulkexecute", "count", "total", "mean", "min", "max", "-", "query"})
+	bssMap := baseMetrics(t.bms).makeStatsMap()
+	names := make([]string, 0, len(bssMap))
+	for name := range bssMap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		bss := bssMap[name]
+		slices.SortFunc(bss, func(a, b baseStat) bool {
+			return a.query < b.query
+		})
+		for _, bs := range bss {
+			w.Write([]string{
+				name,
+				strconv.Itoa(bs.count),
+				formatFloat(bs.total),
+				formatFloat(bs.mean),
+				formatFloat(bs.min),
+				formatFloat(bs.max),
+				"-",
+				bs.query,
+			})
+		}
+	}
 	w.Flush()
 
 	return nil